@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The benchmarks convert a synthetic collation of this size:
+//
+//	go test -run '^$' -bench .
+const benchWitnesses, benchLemmata = 40, 2000
+
+var benchWords = []string{"atha", "saṃśayaḥ", "pramāṇam", "artha", "vattvāt", "saṅkalpo", "iti", "bhāṣyam", "tataḥ", "param", "na", "ca", "tu", "eva", "jñānam", "pratyakṣam"}
+
+// writeSyntheticCollation writes a collation of lemmata in chapters of 100
+// with the given number of witnesses, all present throughout. At every
+// lemma about a third of the witnesses have a variant, some with a pc
+// correction. The same sizes always give the same file.
+func writeSyntheticCollation(filename string, witnesses, lemmata int) {
+	file, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+	f := bufio.NewWriter(file)
+	defer f.Flush()
+	random := rand.New(rand.NewSource(1))
+	sigla := []string{}
+	for i := 0; i < witnesses; i++ {
+		sigla = append(sigla, "M"+strconv.Itoa(10+i))
+	}
+	phrase := func() string {
+		words := []string{}
+		for n := 2 + random.Intn(5); n > 0; n-- {
+			words = append(words, benchWords[random.Intn(len(benchWords))])
+		}
+		return strings.Join(words, " ")
+	}
+
+	f.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<TEI xmlns=\"http://www.tei-c.org/ns/1.0\">\n<teiHeader><fileDesc><sourceDesc>\n<listWit>\n")
+	for _, siglum := range sigla {
+		f.WriteString(`<witness sameAs="` + siglum + `"><abbr>` + siglum + "</abbr></witness>\n")
+	}
+	f.WriteString("</listWit>\n</sourceDesc></fileDesc></teiHeader>\n<text><body>\n<p>")
+	for i := 0; i < lemmata; i++ {
+		if i%100 == 0 {
+			f.WriteString(`<milestone unit="chapter" n="3.1.` + strconv.Itoa(i/100+1) + `"/>` + "\n")
+		}
+		anchor := "a" + strconv.Itoa(i+1)
+		f.WriteString(phrase() + ` <app type="a1" to="#` + anchor + `">`)
+		switch i {
+		case 0:
+			f.WriteString(`<rdg wit="#` + strings.Join(sigla, " #") + `">` + phrase() + "<witStart/></rdg>")
+		case lemmata - 1:
+			f.WriteString(`<rdg wit="#` + strings.Join(sigla, " #") + `">` + phrase() + "<witEnd/></rdg>")
+		default:
+			for j, siglum := range sigla {
+				if random.Intn(3) != 0 {
+					continue
+				}
+				f.WriteString(`<rdg wit="#` + siglum + `">` + phrase() + "</rdg>")
+				if random.Intn(10) == 0 {
+					id := "r" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+					f.WriteString(`<rdg wit="#` + siglum + `" xml:id="` + id + `">` + phrase() + "</rdg>")
+					f.WriteString(`<witDetail target="` + id + `" wit="#` + siglum + `">pc</witDetail>`)
+				}
+			}
+		}
+		f.WriteString(`</app><anchor xml:id="` + anchor + `"/>` + "\n")
+	}
+	f.WriteString("</p>\n</body></text>\n</TEI>\n")
+}
+
+// loadBench writes the synthetic collation to a temporary directory, where
+// the outputs are written too, and parses it. It returns the collation file.
+func loadBench(b *testing.B) string {
+	dir := b.TempDir()
+	b.Chdir(dir)
+	filename := filepath.Join(dir, "bench.xml")
+	writeSyntheticCollation(filename, benchWitnesses, benchLemmata)
+	resetCollation(filename)
+	loadConfig("nutcracker.json")
+	loadCollation()
+	return filename
+}
+
+// samplePeakHeap samples the heap every millisecond until the returned
+// function is called, which reports the peak as peak-MiB. The sampling
+// starts after a collection, so the peak is what the step needs besides
+// the collation it works on.
+func samplePeakHeap(b *testing.B) func() {
+	runtime.GC()
+	var peak atomic.Uint64
+	stop := make(chan bool)
+	done := make(chan bool)
+	go func() {
+		defer close(done)
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			if stats.HeapInuse > peak.Load() {
+				peak.Store(stats.HeapInuse)
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+		b.ReportMetric(float64(peak.Load())/(1<<20), "peak-MiB")
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	filename := loadBench(b)
+	b.ReportAllocs()
+	stop := samplePeakHeap(b)
+	for b.Loop() {
+		resetCollation(filename)
+		loadCollation()
+	}
+	stop()
+}
+
+func BenchmarkReport(b *testing.B) {
+	loadBench(b)
+	b.ReportAllocs()
+	stop := samplePeakHeap(b)
+	for b.Loop() {
+		readingStatuses = []ReadingStatus{}
+		writeReports()
+	}
+	stop()
+}
+
+func BenchmarkWriteCEX(b *testing.B) {
+	loadBench(b)
+	writeReports()
+	b.ReportAllocs()
+	stop := samplePeakHeap(b)
+	for b.Loop() {
+		writeCEX()
+	}
+	stop()
+}
+
+func BenchmarkReadBack(b *testing.B) {
+	loadBench(b)
+	writeReports()
+	writeCEX()
+	b.ReportAllocs()
+	stop := samplePeakHeap(b)
+	for b.Loop() {
+		readBack("output.cex")
+	}
+	stop()
+}
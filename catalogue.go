@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+)
+
+// Manuscript is the catalogue record of one witness from <listWit>.
+type Manuscript struct {
+	Witness    string `json:"witness"`
+	RawSiglum  string `json:"rawSiglum"`
+	Siglum     string `json:"siglum"`
+	Repository string `json:"repository,omitempty"`
+	Shelfmark  string `json:"shelfmark,omitempty"`
+	Date       string `json:"date,omitempty"`
+	Material   string `json:"material,omitempty"`
+	Script     string `json:"script,omitempty"`
+	Folios     string `json:"folios,omitempty"`
+}
+
+var manuscripts = make(map[string]Manuscript)
+
+const manuscriptCollection = "urn:cite2:ducat:manuscripts.temp:"
+
+func nodeText(nodes []Inline) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		if node.Kind == "text" {
+			b.WriteString(node.Text)
+		} else {
+			b.WriteString(nodeText(node.Children))
+		}
+	}
+	return normaliseSpace(b.String())
+}
+
+func nodeAttr(node Inline, name string) string {
+	for _, attr := range node.Attrs {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+func appendField(field *string, value string) {
+	value = normaliseSpace(value)
+	if value == "" {
+		return
+	}
+	if *field != "" {
+		*field = *field + ", " + value
+		return
+	}
+	*field = value
+}
+
+// describeWitness collects the description of a witness from the elements
+// inside <witness>, whether wrapped in a <msDesc> or not. Nested witnesses
+// are described separately.
+func describeWitness(ms *Manuscript, nodes []Inline) {
+	for _, node := range nodes {
+		switch node.Kind {
+		case "text", "abbr", "witness":
+			continue
+		case "settlement", "repository", "institution":
+			appendField(&ms.Repository, nodeText(node.Children))
+		case "idno":
+			appendField(&ms.Shelfmark, nodeText(node.Children))
+		case "origDate", "date":
+			appendField(&ms.Date, nodeText(node.Children))
+			if ms.Date == "" {
+				appendField(&ms.Date, nodeAttr(node, "when"))
+			}
+		case "material":
+			appendField(&ms.Material, nodeText(node.Children))
+		case "scriptNote":
+			appendField(&ms.Script, nodeText(node.Children))
+		case "scriptDesc":
+			if hasChild(node, "scriptNote") {
+				describeWitness(ms, node.Children)
+			} else {
+				appendField(&ms.Script, nodeText(node.Children))
+			}
+		case "measure":
+			unit := nodeAttr(node, "unit")
+			if unit == "folio" || unit == "folios" || unit == "leaf" || unit == "leaves" {
+				if quantity := nodeAttr(node, "quantity"); quantity != "" {
+					appendField(&ms.Folios, quantity)
+				} else {
+					appendField(&ms.Folios, nodeText(node.Children))
+				}
+				continue
+			}
+			describeWitness(ms, node.Children)
+		default:
+			if material := nodeAttr(node, "material"); material != "" {
+				appendField(&ms.Material, material)
+			}
+			if script := nodeAttr(node, "script"); script != "" {
+				appendField(&ms.Script, script)
+			}
+			describeWitness(ms, node.Children)
+		}
+	}
+}
+
+func hasChild(node Inline, kind string) bool {
+	for _, child := range node.Children {
+		if child.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// manuscriptOf returns the catalogue record behind a witness exemplar.
+func manuscriptOf(witkey string) (Manuscript, bool) {
+	ms, ok := manuscripts[witnessOf(witkey)]
+	return ms, ok
+}
+
+func writeCatalogueCEX(f *bufio.Writer) {
+	f.WriteString("#!citedata\n")
+	f.WriteString("urn#siglum#rawSiglum#repository#shelfmark#date#material#script#folios\n")
+	for _, witness := range sortedManuscripts() {
+		ms := manuscripts[witness]
+		f.WriteString(manuscriptCollection + ms.Witness)
+		for _, v := range []string{ms.Siglum, ms.RawSiglum, ms.Repository, ms.Shelfmark, ms.Date, ms.Material, ms.Script, ms.Folios} {
+			f.WriteString("#")
+			f.WriteString(cexField(v))
+		}
+		f.WriteString("\n")
+	}
+	f.WriteString("\n")
+}
+
+func sortedManuscripts() []string {
+	keys := make(map[string]bool)
+	for k := range manuscripts {
+		keys[k] = true
+	}
+	return sortedKeys(keys)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CEX is a parsed CITE Exchange file. Every record keeps the line it was
+// read from.
+type CEX struct {
+	Version     string
+	Library     map[string]string
+	Catalog     []CatalogEntry
+	Nodes       []CTSNode
+	DataModels  []DataModel
+	Collections []CiteCollection
+	Properties  []CiteProperty
+	Data        []CiteData
+	Relations   []Relation
+}
+
+type CatalogEntry struct {
+	URN            string
+	CitationScheme string
+	GroupName      string
+	WorkTitle      string
+	VersionLabel   string
+	ExemplarLabel  string
+	Online         string
+	Language       string
+	Line           int
+}
+
+type CTSNode struct {
+	URN  string
+	Text string
+	Line int
+}
+
+type DataModel struct {
+	Collection  string
+	Model       string
+	Label       string
+	Description string
+	Line        int
+}
+
+type CiteCollection struct {
+	URN               string
+	Description       string
+	LabellingProperty string
+	OrderingProperty  string
+	License           string
+	Line              int
+}
+
+type CiteProperty struct {
+	URN           string
+	Label         string
+	Type          string
+	AuthorityList string
+	Line          int
+}
+
+// CiteData is one #!citedata block: its header and its records, the first
+// field of which is the URN of the object.
+type CiteData struct {
+	Header     []string
+	HeaderLine int
+	Records    []CiteRecord
+	Line       int
+}
+
+type CiteRecord struct {
+	Fields []string
+	Line   int
+}
+
+type Relation struct {
+	Subject string
+	Verb    string
+	Object  string
+	Line    int
+}
+
+// CEXError is a problem found in a CEX file, located by block and line.
+type CEXError struct {
+	Block string
+	Line  int
+	Msg   string
+}
+
+func (e CEXError) String() string {
+	block := e.Block
+	if block == "" {
+		block = "(no block)"
+	}
+	return "line " + strconv.Itoa(e.Line) + " in " + block + ": " + e.Msg
+}
+
+// cexFields says how many fields a record of each block has; ctsdata and
+// citedata split differently and are handled on their own.
+var cexFields = map[string]int{
+	"#!citelibrary":     2,
+	"#!ctscatalog":      8,
+	"#!datamodels":      4,
+	"#!citecollections": 5,
+	"#!citeproperties":  4,
+	"#!relations":       3,
+}
+
+// cexHeaders are the blocks whose first record is a header line.
+var cexHeaders = map[string]bool{
+	"#!ctscatalog":      true,
+	"#!datamodels":      true,
+	"#!citecollections": true,
+	"#!citeproperties":  true,
+	"#!citedata":        true,
+}
+
+// parseCEX reads a CEX file. Lines outside a known block and records with
+// the wrong number of fields are reported and skipped.
+func parseCEX(r io.Reader) (CEX, []CEXError) {
+	cex := CEX{Library: make(map[string]string)}
+	errors := []CEXError{}
+	block := ""
+	header := false
+	lineNo := 0
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if strings.HasPrefix(line, "#!") {
+			block = strings.TrimSpace(line)
+			switch block {
+			case "#!cexversion", "#!citelibrary", "#!ctscatalog", "#!ctsdata", "#!datamodels", "#!citecollections", "#!citeproperties", "#!relations":
+			case "#!citedata":
+				cex.Data = append(cex.Data, CiteData{Line: lineNo})
+			default:
+				errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: "unknown block"})
+			}
+			header = cexHeaders[block]
+			continue
+		}
+		if header {
+			header = false
+			if block == "#!citedata" {
+				cex.Data[len(cex.Data)-1].Header = strings.Split(line, "#")
+				cex.Data[len(cex.Data)-1].HeaderLine = lineNo
+			}
+			continue
+		}
+		switch block {
+		case "":
+			errors = append(errors, CEXError{Line: lineNo, Msg: "text before the first block"})
+			continue
+		case "#!cexversion":
+			if cex.Version != "" {
+				errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: "more than one version"})
+			}
+			cex.Version = strings.TrimSpace(line)
+			continue
+		case "#!ctsdata":
+			parts := strings.SplitN(line, "#", 2)
+			if len(parts) < 2 {
+				errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: "expected urn#text"})
+				continue
+			}
+			cex.Nodes = append(cex.Nodes, CTSNode{URN: parts[0], Text: parts[1], Line: lineNo})
+			continue
+		case "#!citedata":
+			data := &cex.Data[len(cex.Data)-1]
+			fields := strings.Split(line, "#")
+			if len(fields) != len(data.Header) {
+				errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: "expected " + strconv.Itoa(len(data.Header)) + " fields as in the header, found " + strconv.Itoa(len(fields))})
+				continue
+			}
+			data.Records = append(data.Records, CiteRecord{Fields: fields, Line: lineNo})
+			continue
+		}
+		fields := strings.Split(line, "#")
+		if expected, ok := cexFields[block]; ok && len(fields) != expected {
+			errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: "expected " + strconv.Itoa(expected) + " fields, found " + strconv.Itoa(len(fields))})
+			continue
+		}
+		switch block {
+		case "#!citelibrary":
+			cex.Library[fields[0]] = fields[1]
+		case "#!ctscatalog":
+			cex.Catalog = append(cex.Catalog, CatalogEntry{URN: fields[0], CitationScheme: fields[1], GroupName: fields[2], WorkTitle: fields[3], VersionLabel: fields[4], ExemplarLabel: fields[5], Online: fields[6], Language: fields[7], Line: lineNo})
+		case "#!datamodels":
+			cex.DataModels = append(cex.DataModels, DataModel{Collection: fields[0], Model: fields[1], Label: fields[2], Description: fields[3], Line: lineNo})
+		case "#!citecollections":
+			cex.Collections = append(cex.Collections, CiteCollection{URN: fields[0], Description: fields[1], LabellingProperty: fields[2], OrderingProperty: fields[3], License: fields[4], Line: lineNo})
+		case "#!citeproperties":
+			cex.Properties = append(cex.Properties, CiteProperty{URN: fields[0], Label: fields[1], Type: fields[2], AuthorityList: fields[3], Line: lineNo})
+		case "#!relations":
+			cex.Relations = append(cex.Relations, Relation{Subject: fields[0], Verb: fields[1], Object: fields[2], Line: lineNo})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errors = append(errors, CEXError{Block: block, Line: lineNo, Msg: err.Error()})
+	}
+	return cex, errors
+}
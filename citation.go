@@ -0,0 +1,48 @@
+package main
+
+import "strings"
+
+// Citation configures how passages are cited. Levels lists the milestone
+// units, outermost first, whose n values make up the reference a lemma number
+// is appended to; a milestone clears the levels below it. FrontMatter is
+// "skip" to leave out the text before the first milestone, filing stray apps
+// there under FrontMatterReference, or "cite" to cite it under that reference.
+type Citation struct {
+	Scheme               string   `json:"scheme"`
+	Levels               []string `json:"levels"`
+	FrontMatter          string   `json:"frontMatter"`
+	FrontMatterReference string   `json:"frontMatterReference"`
+}
+
+// citationLevel returns the level a milestone unit sets, or -1.
+func citationLevel(unit string) int {
+	for i, level := range config.Citation.Levels {
+		if level == unit {
+			return i
+		}
+	}
+	return -1
+}
+
+// citeMilestone sets level to n and clears the levels below it.
+func citeMilestone(values []string, level int, n string) []string {
+	if len(values) != len(config.Citation.Levels) {
+		values = make([]string, len(config.Citation.Levels))
+	}
+	values[level] = strings.TrimSpace(n)
+	for i := level + 1; i < len(values); i++ {
+		values[i] = ""
+	}
+	return values
+}
+
+// citationReference joins the levels set so far.
+func citationReference(values []string) string {
+	parts := []string{}
+	for _, v := range values {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+)
+
+// Config holds the settings read from the -config file. Fields missing from
+// the file keep their defaults.
+type Config struct {
+	// MissingReading decides what a witness inside its range without an
+	// explicit reading reads: "inherit" takes over the base text (negative
+	// apparatus), "unknown" marks it as not collated (positive apparatus)
+	// and "error" stops the conversion.
+	MissingReading string  `json:"missingReading"`
+	Markers        Markers `json:"markers"`
+	// Markup gives the opening and closing brackets used in the CEX for
+	// elements inside readings, by element name.
+	Markup map[string][2]string `json:"markup"`
+	// Sigla are the rules, applied in order, that turn the sigla of
+	// <listWit> into URN components. A configured list replaces the defaults.
+	Sigla []SiglumRule `json:"sigla"`
+	// Groups defines witness groups by the siglum used for them in @wit, in
+	// addition to the groups nested in <listWit>.
+	Groups   map[string]WitnessGroup `json:"groups"`
+	Citation Citation                `json:"citation"`
+	// Editions chooses the levels written to the CEX: "passage" for a
+	// version citing whole lemmata, "token" for the tokenised exemplar.
+	Editions []string `json:"editions"`
+	// Tokens is the token addressing scheme: "suffix" appends _N to the
+	// passage, "level" adds a citation level .N and "subref" cites the word
+	// on the passage-level version as a CTS subreference.
+	Tokens     string            `json:"tokens"`
+	Alignments AlignmentSettings `json:"alignments"`
+}
+
+type Markers struct {
+	Omitted     string `json:"omitted"`
+	Unavailable string `json:"unavailable"`
+	Unknown     string `json:"unknown"`
+}
+
+var config = Config{
+	MissingReading: "inherit",
+	Markers: Markers{
+		Omitted:     "[[om.]]",
+		Unavailable: "[[NA]]",
+		Unknown:     "[[?]]",
+	},
+	Markup: map[string][2]string{
+		"gap":      {"[...]", ""},
+		"unclear":  {"(", "?)"},
+		"supplied": {"⟨", "⟩"},
+		"del":      {"⟦", "⟧"},
+		"add":      {"\\", "/"},
+		"hi":       {"", ""},
+		"sic":      {"", "(!)"},
+		"corr":     {"", ""},
+		"choice":   {"", ""},
+	},
+	Sigla: defaultSiglumRules,
+	Citation: Citation{
+		Scheme:               "NyayaScheme",
+		Levels:               []string{"chapter"},
+		FrontMatter:          "skip",
+		FrontMatterReference: "3.1.1",
+	},
+	Editions: []string{"passage", "token"},
+	Tokens:   "suffix",
+	Alignments: AlignmentSettings{
+		Collection:  "urn:cite2:ducat:alignments.temp:",
+		Editor:      "Brucheion User",
+		Description: "Textual Alignment",
+	},
+}
+
+func loadConfig(filename string) {
+	defer compileSiglumRules()
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Println("Using default configuration:", err)
+		return
+	}
+	defer f.Close()
+	decoder := json.NewDecoder(f)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		log.Fatalln(filename+":", err)
+	}
+	switch config.MissingReading {
+	case "inherit", "unknown", "error":
+	default:
+		log.Fatalln(filename+": missingReading must be inherit, unknown or error, not", config.MissingReading)
+	}
+	switch config.Citation.FrontMatter {
+	case "skip", "cite":
+	default:
+		log.Fatalln(filename+": citation.frontMatter must be skip or cite, not", config.Citation.FrontMatter)
+	}
+	if len(config.Editions) == 0 {
+		log.Fatalln(filename + ": editions names no level")
+	}
+	for _, level := range config.Editions {
+		known := false
+		for _, v := range editionLevels {
+			if level == v {
+				known = true
+			}
+		}
+		if !known {
+			log.Fatalln(filename+": editions must be passage or token, not", level)
+		}
+	}
+	switch config.Tokens {
+	case "suffix", "level":
+	case "subref":
+		if !emitsLevel("passage") {
+			log.Fatalln(filename + ": subref tokens need the passage level in editions")
+		}
+	default:
+		log.Fatalln(filename+": tokens must be suffix, level or subref, not", config.Tokens)
+	}
+	if !strings.HasPrefix(config.Alignments.Collection, "urn:cite2:") || !strings.HasSuffix(config.Alignments.Collection, ":") {
+		log.Fatalln(filename+": alignments.collection must be a CITE2 collection URN ending in :, not", config.Alignments.Collection)
+	}
+	if len(config.Citation.Levels) == 0 {
+		log.Fatalln(filename + ": citation.levels names no milestone unit")
+	}
+	log.Println("Loaded configuration from", filename)
+}
+
+// markerStatus returns the typed status of a reading that consists of one of
+// the markers, or "" for actual text.
+func markerStatus(reading string) string {
+	switch reading {
+	case config.Markers.Omitted:
+		return "omitted"
+	case config.Markers.Unavailable:
+		return "unavailable"
+	case config.Markers.Unknown:
+		return "unknown"
+	}
+	return ""
+}
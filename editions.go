@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// editionLevels are the levels config.Editions may choose from: "passage"
+// for a version citing whole lemmata, "token" for the exemplar derived from
+// it with one passage per token.
+var editionLevels = []string{"passage", "token"}
+
+func emitsLevel(level string) bool {
+	for _, v := range config.Editions {
+		if v == level {
+			return true
+		}
+	}
+	return false
+}
+
+// versionURN is the passage-level version of the base ("DFG") or a witness.
+func versionURN(witkey string) string {
+	return passageBase + witkey + ":"
+}
+
+// exemplarURN is the tokenised exemplar derived from versionURN.
+func exemplarURN(witkey string) string {
+	return passageBase + witkey + ".token:"
+}
+
+// writesTokens tells whether a token exemplar is written. Subreferences
+// address the tokens on the passage-level version instead.
+func writesTokens() bool {
+	return emitsLevel("token") && config.Tokens != "subref"
+}
+
+// citesTokens tells whether alignments and notes cite single tokens.
+func citesTokens() bool {
+	return writesTokens() || config.Tokens == "subref"
+}
+
+// tokenURN cites token n of the text of a witness at a passage, or the whole
+// passage when tokens are not cited. A marker reading has no words to
+// subreference and is cited as a whole.
+func tokenURN(witkey, passage, text string, n int) string {
+	if !citesTokens() {
+		return versionURN(witkey) + passage
+	}
+	switch config.Tokens {
+	case "level":
+		return exemplarURN(witkey) + passage + "." + strconv.Itoa(n)
+	case "subref":
+		if markerStatus(text) != "" {
+			return versionURN(witkey) + passage
+		}
+		return versionURN(witkey) + passage + "@" + subreference(customSplit(text), n)
+	}
+	return exemplarURN(witkey) + passage + "_" + strconv.Itoa(n)
+}
+
+// subreference quotes token n with its occurrence among the tokens.
+func subreference(tokens []string, n int) string {
+	word := strings.TrimSpace(tokens[n-1])
+	occurrence := 0
+	for _, token := range tokens[:n] {
+		if strings.TrimSpace(token) == word {
+			occurrence++
+		}
+	}
+	return word + "[" + strconv.Itoa(occurrence) + "]"
+}
+
+// rangeURN cites the tokens from first to last.
+func rangeURN(first, last string) string {
+	return first + "-" + last[strings.LastIndex(last, ":")+1:]
+}
+
+// editionURNs lists the emitted versions and exemplars of a witness.
+func editionURNs(witkey string) []string {
+	urns := []string{}
+	if emitsLevel("passage") {
+		urns = append(urns, versionURN(witkey))
+	}
+	if writesTokens() {
+		urns = append(urns, exemplarURN(witkey))
+	}
+	return urns
+}
+
+// editionWitnesses lists the base and the witnesses in the order their
+// editions are written.
+func editionWitnesses() []string {
+	return append([]string{"DFG"}, sortedKeys(witnessMap)...)
+}
+
+// editionText is the text of the base or a witness at passage i.
+func editionText(witkey string, i int) string {
+	if witkey == "DFG" {
+		return basetext[i]
+	}
+	if reading, ok := positionMap[passageURNs[i]][witkey]; ok {
+		return reading
+	}
+	reading, source := inheritReading(passageURNs[i], witkey, basetext[i])
+	requireReading(passageURNs[i], witkey, source)
+	return reading
+}
+
+// alignedPassages splits the text of a witness at a passage into what an
+// alignment aligns: its tokens when tokens are cited, else the passage.
+func alignedPassages(witkey, passage, text string) []CTSPassage {
+	parent := CTSPassage{ID: versionURN(witkey) + passage, Passage: text}
+	if !citesTokens() {
+		return []CTSPassage{parent}
+	}
+	tokens := []CTSPassage{}
+	for index, element := range customSplit(text) {
+		token := CTSPassage{ID: tokenURN(witkey, passage, text, index+1), Passage: element}
+		if writesTokens() && emitsLevel("passage") {
+			token.Parent = parent.ID
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens
+}
+
+// editionPassages returns the passages of one of the editions of a witness
+// at a passage: the passage itself in the version, its tokens in the
+// exemplar.
+func editionPassages(editionURN, witkey, passage, text string) []CTSPassage {
+	if editionURN == versionURN(witkey) {
+		return []CTSPassage{{ID: versionURN(witkey) + passage, Passage: text}}
+	}
+	return alignedPassages(witkey, passage, text)
+}
+
+// writeEditionData writes the #!ctsdata lines of the editions of a witness,
+// generating the text and the tokens of each lemma once. The exemplar is
+// held until the version is written; the isPartOf relations of its tokens
+// go to partOf when it is given.
+func writeEditionData(f, partOf *bufio.Writer, witkey string) {
+	version := versionURN(witkey)
+	versions := emitsLevel("passage")
+	tokens := writesTokens()
+	var exemplar bytes.Buffer
+	for i, keyStr := range passageURNs {
+		text := editionText(witkey, i)
+		if versions {
+			f.WriteString(version)
+			f.WriteString(keyStr)
+			f.WriteString("#")
+			f.WriteString(text)
+			f.WriteString("\n")
+		}
+		if !tokens {
+			continue
+		}
+		for _, token := range alignedPassages(witkey, keyStr, text) {
+			exemplar.WriteString(token.ID)
+			exemplar.WriteString("#")
+			exemplar.WriteString(token.Passage)
+			exemplar.WriteString("\n")
+			if partOf != nil {
+				partOf.WriteString(token.ID)
+				partOf.WriteString("#urn:cite2:ducat:verbs.temp:isPartOf#")
+				partOf.WriteString(token.Parent)
+				partOf.WriteString("\n")
+			}
+		}
+	}
+	f.Write(exemplar.Bytes())
+}
+
+// lemmaAlignment generates the alignment of passage i.
+func lemmaAlignment(i int) Alignment {
+	keyStr := passageURNs[i]
+	alignment := Alignment{ID: config.Alignments.Collection + keyStr, Label: alignmentLabel(keyStr, basetext[i])}
+	for _, witkey := range editionWitnesses() {
+		alignment.Token = append(alignment.Token, alignedPassages(witkey, keyStr, editionText(witkey, i))...)
+	}
+	return alignment
+}
+
+// writeCatalogEntries writes the #!ctscatalog lines of a witness.
+func writeCatalogEntries(f *bufio.Writer, witkey string) {
+	if emitsLevel("passage") {
+		writeCatalogEntry(f, versionURN(witkey), "")
+	}
+	if writesTokens() {
+		writeCatalogEntry(f, exemplarURN(witkey), "Brucheion-Tokenised")
+	}
+}
+
+func writeCatalogEntry(f *bufio.Writer, urn, exemplarLabel string) {
+	f.WriteString(urn)
+	f.WriteString("#")
+	f.WriteString(config.Citation.Scheme)
+	f.WriteString("#")
+	f.WriteString("GroupName")
+	f.WriteString("#")
+	f.WriteString("WorkTitle")
+	f.WriteString("#")
+	f.WriteString("VersionLabel")
+	f.WriteString("#")
+	f.WriteString(exemplarLabel)
+	f.WriteString("#")
+	f.WriteString("TRUE")
+	f.WriteString("#")
+	f.WriteString("san")
+	f.WriteString("\n")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type EquivalenceRule struct {
+	Label       string
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+var equivalenceRules = []EquivalenceRule{}
+var equivalenceLabels = []string{}
+var suppressedCount = make(map[string]int)
+
+// equivalenceMap records, per passage and witness, the rule that made an
+// explicit reading agree with the base text.
+var equivalenceMap = make(map[string]map[string]string)
+
+// loadEquivalences reads one rule per line in the form label#pattern#replacement.
+// Patterns are Go regular expressions; lines starting with // are comments.
+// Several lines may share a label, their counts are reported together.
+func loadEquivalences(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Println("No equivalence rules loaded:", err)
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		parts := strings.Split(line, "#")
+		if len(parts) != 3 {
+			log.Fatalln(filename, "line", lineNo, "is not of the form label#pattern#replacement")
+		}
+		pattern, err := regexp.Compile(parts[1])
+		if err != nil {
+			log.Fatalln(filename, "line", lineNo, err)
+		}
+		if _, ok := suppressedCount[parts[0]]; !ok {
+			equivalenceLabels = append(equivalenceLabels, parts[0])
+			suppressedCount[parts[0]] = 0
+		}
+		equivalenceRules = append(equivalenceRules, EquivalenceRule{Label: parts[0], Pattern: pattern, Replacement: parts[2]})
+	}
+	if err := scanner.Err(); err != nil {
+		panic(err)
+	}
+	log.Println("Loaded", len(equivalenceRules), "equivalence rules.")
+}
+
+func normaliseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// equivalentReading applies the rules in order to both the reading and the
+// base text and returns the label of the rule after which they agree.
+func equivalentReading(reading, base string) (string, bool) {
+	a := normaliseSpace(reading)
+	b := normaliseSpace(base)
+	if a == b {
+		return "", false
+	}
+	for _, rule := range equivalenceRules {
+		a = rule.Pattern.ReplaceAllString(a, rule.Replacement)
+		b = rule.Pattern.ReplaceAllString(b, rule.Replacement)
+		if a == b {
+			return rule.Label, true
+		}
+	}
+	return "", false
+}
+
+func suppressReading(passage, witness, label string) {
+	if len(equivalenceMap[passage]) == 0 {
+		equivalenceMap[passage] = make(map[string]string)
+	}
+	equivalenceMap[passage][witness] = label
+	suppressedCount[label]++
+}
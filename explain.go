@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ReadingSource tells where the reading of a witness at a passage came from.
+// Kind is "reading" for an explicit rdg, "witDetail" for a reading qualified
+// by a witDetail, "inherited" when the base text was taken over, "unknown"
+// when it was not collated and "absent" when the witness is outside its range. Rule names the witDetail or the
+// fallback that located the witness in witnessRange. "missing" marks a
+// witness without a reading inside its range when missingReading is error.
+type ReadingSource struct {
+	Kind    string `json:"kind"`
+	Rule    string `json:"rule,omitempty"`
+	Layer   string `json:"layer,omitempty"`
+	Witness string `json:"witness,omitempty"`
+	Group   string `json:"group,omitempty"`
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line,omitempty"`
+	Column  int    `json:"column,omitempty"`
+}
+
+func (source ReadingSource) String() string {
+	if source.Group != "" && (source.Kind == "reading" || source.Kind == "witDetail") {
+		group := source
+		group.Group = ""
+		return group.String() + ", cited as " + source.Group
+	}
+	if source.File != "" && (source.Kind == "reading" || source.Kind == "witDetail") {
+		located := source
+		located.File = ""
+		return located.String() + inFile(source.File)
+	}
+	switch source.Kind {
+	case "":
+		return ""
+	case "reading":
+		return "rdg (" + source.Layer + ") at line " + fmt.Sprintf("%d:%d", source.Line, source.Column)
+	case "witDetail":
+		return "witDetail " + source.Rule + " (" + source.Layer + ") at line " + fmt.Sprintf("%d:%d", source.Line, source.Column)
+	case "inherited":
+		return "base text, " + source.Witness + " present by " + source.Rule
+	case "unknown":
+		return "not collated, " + source.Witness + " present by " + source.Rule
+	default:
+		return source.Kind
+	}
+}
+
+var sourceKinds = map[string]string{
+	"reading":   "explicit rdg",
+	"witDetail": "rdg qualified by witDetail",
+	"inherited": "inherited from base",
+	"unknown":   "not collated",
+	"absent":    "absent",
+	"missing":   "no reading (missingReading is error)",
+}
+
+var readingSources = map[string]map[string]ReadingSource{}
+var anchorLocations = map[string]ReadingSource{}
+var inverseSiglaMap = make(map[string]string)
+var noteExtract = regexp.MustCompile(`_Note[^_]+`)
+
+func buildInverseSigla() {
+	for k, v := range siglaMap {
+		inverseSiglaMap[v] = k
+	}
+}
+
+func dropLastPart(witkey string) string {
+	return strings.Join(strings.Split(witkey, "_")[:len(strings.Split(witkey, "_"))-1], "_")
+}
+
+// witnessCandidates lists the sigla tried, in order, to find the witness
+// behind witkey in witnessRange. They are looked up for every reading the
+// CEX writer generates and are kept once worked out.
+func witnessCandidates(witkey string) [][2]string {
+	if candidates, ok := candidateCache[witkey]; ok {
+		return candidates
+	}
+	candidates := [][2]string{
+		{"full key", witkey},
+		{"key without last _ part", dropLastPart(witkey)},
+		{"key without _Note", noteExtract.ReplaceAllString(witkey, "")},
+		{"key without _Note and last _ part", dropLastPart(noteExtract.ReplaceAllString(witkey, ""))},
+	}
+	candidateCache[witkey] = candidates
+	return candidates
+}
+
+var candidateCache = make(map[string][][2]string)
+
+// witnessOf returns the witness id behind a resolved siglum.
+func witnessOf(witkey string) string {
+	for _, candidate := range witnessCandidates(witkey) {
+		if witness, ok := inverseSiglaMap[candidate[1]]; ok {
+			return witness
+		}
+	}
+	return ""
+}
+
+// baseWitnessOf returns the <listWit> witness behind a resolved siglum, so
+// that a hand such as M11_2pc belongs to M11.
+func baseWitnessOf(witkey string) string {
+	for _, candidate := range witnessCandidates(witkey) {
+		if witness, ok := inverseSiglaMap[candidate[1]]; ok && siglumTransforms[witness] != nil {
+			return witness
+		}
+	}
+	return witnessOf(witkey)
+}
+
+// inheritReading decides the reading of a witness without an explicit
+// reading at keyStr. Outside its range the witness is unavailable; inside,
+// config.MissingReading decides between the base text and the unknown marker,
+// or reports the reading as missing, which stops a conversion in
+// requireReading.
+func inheritReading(keyStr, witkey, value string) (string, ReadingSource) {
+	for _, candidate := range witnessCandidates(witkey) {
+		witness := inverseSiglaMap[candidate[1]]
+		if !witnessRange[keyStr][witness] {
+			continue
+		}
+		switch config.MissingReading {
+		case "unknown":
+			return config.Markers.Unknown, ReadingSource{Kind: "unknown", Rule: candidate[0], Witness: witness}
+		case "error":
+			return "", ReadingSource{Kind: "missing", Rule: candidate[0], Witness: witness}
+		}
+		return value, ReadingSource{Kind: "inherited", Rule: candidate[0], Witness: witness}
+	}
+	return config.Markers.Unavailable, ReadingSource{Kind: "absent"}
+}
+
+// requireReading stops the conversion at a reading inheritReading found
+// missing.
+func requireReading(keyStr, witkey string, source ReadingSource) {
+	if source.Kind == "missing" {
+		log.Fatalf("no reading for %s at %s (missingReading is error)", witkey, keyStr)
+	}
+}
+
+// passageKey accepts a lemma such as 3.1.1.5 or any CTS URN of one of its
+// tokens and returns the lemma.
+func passageKey(urn string) string {
+	token := strings.Contains(urn, ".token:")
+	if i := strings.LastIndex(urn, ":"); i >= 0 {
+		urn = urn[i+1:]
+	}
+	if i := strings.IndexAny(urn, "_@"); i >= 0 {
+		return urn[:i]
+	}
+	if token && config.Tokens == "level" {
+		return dropLastLevel(urn)
+	}
+	return urn
+}
+
+func dropLastLevel(urn string) string {
+	if i := strings.LastIndex(urn, "."); i >= 0 {
+		return urn[:i]
+	}
+	return urn
+}
+
+func explain(args []string) {
+	if len(args) < 1 {
+		log.Fatalln("usage: nutcracker explain <passage-urn> [witness]")
+	}
+	keyStr := passageKey(args[0])
+	witnessFilter := ""
+	if len(args) > 1 {
+		witnessFilter = args[1]
+	}
+	loadCollation()
+
+	index := -1
+	for i, v := range passageURNs {
+		if v == keyStr {
+			index = i
+		}
+	}
+	if index < 0 {
+		log.Fatalln("no passage", keyStr)
+	}
+	value := basetext[index]
+
+	anchor := anchorLocations[keyStr]
+	if anchor.Kind != "" {
+		fmt.Printf("Passage %s (anchor %s at line %d:%d%s)\n", keyStr, anchor.Rule, anchor.Line, anchor.Column, inFile(anchor.File))
+	} else {
+		fmt.Printf("Passage %s (after the last anchor)\n", keyStr)
+	}
+	fmt.Printf("Base: %q\n\n", value)
+
+	matches := func(witkey string) bool {
+		if witnessFilter == "" || witkey == witnessFilter {
+			return true
+		}
+		for _, candidate := range witnessCandidates(witkey) {
+			if inverseSiglaMap[candidate[1]] == witnessFilter {
+				return true
+			}
+		}
+		return false
+	}
+
+	found := false
+	for _, witkey := range sortedKeys(witnessMap) {
+		if !matches(witkey) {
+			continue
+		}
+		found = true
+		reading, ok := positionMap[keyStr][witkey]
+		source := readingSources[keyStr][witkey]
+		if !ok {
+			reading, source = inheritReading(keyStr, witkey, value)
+		}
+		fmt.Printf("%s\n  reading: %q\n  source:  %s\n", witkey, reading, sourceKinds[source.Kind])
+		switch source.Kind {
+		case "reading", "witDetail":
+			fmt.Printf("  from:    %s\n", source)
+			if label, equal := equivalentReading(reading, value); equal {
+				fmt.Printf("  agrees with the base by equivalence rule %s\n", label)
+			}
+		case "inherited", "unknown", "missing":
+			fmt.Printf("  rule:    %s (%s -> witness %s)\n", source.Rule, witkey, source.Witness)
+		case "absent":
+			fmt.Printf("  rule:    no candidate present in witnessRange[%s]:", keyStr)
+			for _, candidate := range witnessCandidates(witkey) {
+				fmt.Printf(" %s=%q", candidate[0], inverseSiglaMap[candidate[1]])
+			}
+			fmt.Println()
+		}
+	}
+	for _, witkey := range sortedStringKeys(secPositionMap[keyStr]) {
+		if !matches(witkey) {
+			continue
+		}
+		found = true
+		fmt.Printf("%s (correction or conjecture)\n  reading: %q\n  from:    %s\n", witkey, secPositionMap[keyStr][witkey], readingSources[keyStr][witkey])
+	}
+	if !found {
+		log.Fatalln("no witness", witnessFilter)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// WitnessGroup is a family of witnesses cited under one siglum, defined by
+// nested <witness> elements in <listWit> or under "groups" in the config.
+// Members are witness ids or further groups.
+type WitnessGroup struct {
+	Label   string   `json:"label"`
+	Members []string `json:"members"`
+}
+
+var witnessGroups = make(map[string]WitnessGroup)
+
+// groupsearch finds group sigla in @wit, which witsearch does not match.
+var groupsearch *regexp.Regexp
+
+// loadGroups registers the groups of the config before <listWit> is read,
+// so that they take precedence over groups of the same id there.
+func loadGroups() {
+	for id, group := range config.Groups {
+		if group.Label == "" {
+			group.Label = id
+		}
+		witnessGroups[id] = group
+	}
+}
+
+// expandWitness returns the witnesses a siglum in @wit stands for and, for a
+// group, its label.
+func expandWitness(id string) ([]string, string) {
+	group, ok := witnessGroups[id]
+	if !ok {
+		return []string{id}, ""
+	}
+	return groupMembers(id, map[string]bool{}), group.Label
+}
+
+func groupMembers(id string, seen map[string]bool) []string {
+	if seen[id] {
+		warn("group", id, "contains itself")
+		return nil
+	}
+	seen[id] = true
+	members := []string{}
+	for _, member := range witnessGroups[id].Members {
+		if _, ok := witnessGroups[member]; ok {
+			members = append(members, groupMembers(member, seen)...)
+			continue
+		}
+		members = append(members, member)
+	}
+	return members
+}
+
+func compileGroupSearch() {
+	ids := []string{}
+	for _, id := range sortedGroups() {
+		ids = append(ids, regexp.QuoteMeta(id))
+	}
+	if len(ids) > 0 {
+		groupsearch = regexp.MustCompile(`#(` + strings.Join(ids, "|") + `)[\s"]`)
+	}
+}
+
+func sortedGroups() []string {
+	keys := []string{}
+	for k := range witnessGroups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// groupSigla replaces the sigla of all members of a group by its label where
+// the readings were cited under that group.
+func groupSigla(sigla []string, groups []string) []string {
+	present := make(map[string]bool)
+	for _, siglum := range sigla {
+		present[siglum] = true
+	}
+	result := []string{}
+	covered := make(map[string]bool)
+	for _, id := range sortedGroups() {
+		group := witnessGroups[id]
+		cited := false
+		for _, label := range groups {
+			if label == group.Label {
+				cited = true
+			}
+		}
+		if !cited {
+			continue
+		}
+		members, _ := expandWitness(id)
+		complete := len(members) > 0
+		for _, member := range members {
+			if !present[siglaMap[member]] || covered[siglaMap[member]] {
+				complete = false
+			}
+		}
+		if !complete {
+			continue
+		}
+		for _, member := range members {
+			covered[siglaMap[member]] = true
+		}
+		result = append(result, group.Label)
+	}
+	for _, siglum := range sigla {
+		if !covered[siglum] {
+			result = append(result, siglum)
+		}
+	}
+	return result
+}
+
+func groupReport() []GroupReport {
+	groups := []GroupReport{}
+	for _, id := range sortedGroups() {
+		members, label := expandWitness(id)
+		sigla := []string{}
+		for _, member := range members {
+			sigla = append(sigla, siglaMap[member])
+		}
+		groups = append(groups, GroupReport{Group: id, Label: label, Members: members, Sigla: strings.Join(sigla, " ")})
+	}
+	return groups
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type htmlChapter struct {
+	ID       string
+	File     string
+	Lemmata  int
+	Passages []htmlPassage
+	index    int
+	last     int
+}
+
+type htmlPassage struct {
+	URN         string
+	Anchor      string
+	Base        string
+	Variant     bool
+	Readings    []ReadingReport
+	Conjectures []ReadingReport
+}
+
+type htmlTimeline struct {
+	Witness string
+	Cells   []htmlCell
+}
+
+type htmlCell struct {
+	URN     string
+	Chapter string
+	Anchor  string
+	Present bool
+}
+
+func chapterOf(urn string) string {
+	parts := strings.Split(urn, ".")
+	return strings.Join(parts[:len(parts)-1], ".")
+}
+
+func htmlAnchor(urn string) string {
+	return "p" + strings.Replace(urn, ".", "-", -1)
+}
+
+// htmlSite is the viewer while the report is written: the chapters and the
+// witness presence timeline. Each chapter page is written as soon as its
+// last passage is in, and the index with the timeline at the end.
+type htmlSite struct {
+	dir           string
+	chapters      []*htmlChapter
+	byChapter     map[string]*htmlChapter
+	timelines     []htmlTimeline
+	timelineIndex map[string]int
+	next          int
+}
+
+// startHTML begins a static site with one page per chapter and an index
+// holding the witness presence timeline. Pages need neither a server nor
+// any external assets.
+func startHTML(dir string) *htmlSite {
+	log.Println("writing HTML viewer to", dir+"...")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		panic(err)
+	}
+	site := &htmlSite{dir: dir, byChapter: make(map[string]*htmlChapter), timelineIndex: make(map[string]int)}
+	for i := range basetext {
+		chapterID := chapterOf(passageURNs[i])
+		chapter, ok := site.byChapter[chapterID]
+		if !ok {
+			chapter = &htmlChapter{ID: chapterID, File: "chapter-" + chapterID + ".html", index: len(site.chapters)}
+			site.byChapter[chapterID] = chapter
+			site.chapters = append(site.chapters, chapter)
+		}
+		chapter.Lemmata++
+		chapter.last = i
+	}
+	return site
+}
+
+func writeHTMLPassages(site *htmlSite, passages []PassageReport, conjectures map[string][]ReadingReport) {
+	for _, passage := range passages {
+		chapter := site.byChapter[chapterOf(passage.URN)]
+		htmlPsg := htmlPassage{
+			URN:         passage.URN,
+			Anchor:      htmlAnchor(passage.URN),
+			Base:        passage.Base,
+			Readings:    passage.Readings,
+			Conjectures: conjectures[passage.URN],
+		}
+		for _, reading := range passage.Readings {
+			if reading.Status == "reading" && reading.Equivalence == "" && normaliseSpace(reading.Reading) != normaliseSpace(passage.Base) {
+				htmlPsg.Variant = true
+			}
+			i, ok := site.timelineIndex[reading.Witness]
+			if !ok {
+				i = len(site.timelines)
+				site.timelineIndex[reading.Witness] = i
+				site.timelines = append(site.timelines, htmlTimeline{Witness: reading.Witness})
+			}
+			site.timelines[i].Cells = append(site.timelines[i].Cells, htmlCell{
+				URN:     passage.URN,
+				Chapter: chapter.File,
+				Anchor:  htmlPsg.Anchor,
+				Present: reading.Status != "absent",
+			})
+		}
+		if len(htmlPsg.Conjectures) > 0 {
+			htmlPsg.Variant = true
+		}
+		chapter.Passages = append(chapter.Passages, htmlPsg)
+		if site.next == chapter.last {
+			writeHTMLChapter(site, chapter)
+		}
+		site.next++
+	}
+}
+
+// writeHTMLChapter writes the page of a chapter and lets go of its readings.
+func writeHTMLChapter(site *htmlSite, chapter *htmlChapter) {
+	data := map[string]interface{}{"Chapter": chapter}
+	if chapter.index > 0 {
+		data["Previous"] = site.chapters[chapter.index-1]
+	}
+	if chapter.index < len(site.chapters)-1 {
+		data["Next"] = site.chapters[chapter.index+1]
+	}
+	writeTemplate(filepath.Join(site.dir, chapter.File), htmlChapterPage, data)
+	chapter.Passages = nil
+}
+
+func finishHTML(site *htmlSite, report Report) {
+	writeTemplate(filepath.Join(site.dir, "index.html"), htmlIndex, map[string]interface{}{
+		"Chapters":  site.chapters,
+		"Timelines": site.timelines,
+		"Summary":   report.Summary,
+		"Warnings":  report.Warnings,
+	})
+}
+
+func writeTemplate(filename string, tmpl *template.Template, data interface{}) {
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if err := tmpl.Execute(f, data); err != nil {
+		panic(err)
+	}
+}
+
+const htmlStyle = `<style>
+body { font-family: "Noto Serif", "Gentium Plus", serif; margin: 0; display: flex; }
+main { flex: 3; padding: 1em 2em; line-height: 1.8; }
+aside { flex: 2; padding: 1em; border-left: 1px solid #ccc; position: sticky; top: 0; height: 100vh; overflow-y: auto; background: #fafafa; }
+nav { margin-bottom: 1em; }
+.lemma { cursor: pointer; border-bottom: 1px dotted #999; }
+.lemma.variant { background: #fff3c4; }
+.lemma.selected { background: #ffd966; }
+.app { display: none; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 6px; text-align: left; vertical-align: top; }
+tr.absent { color: #aaa; }
+.status { font-size: 80%; color: #666; }
+.timeline { display: flex; height: 12px; margin: 2px 0; }
+.timeline a { flex: 1; min-width: 1px; background: #4a7; }
+.timeline a.absent { background: #ddd; }
+.witness { width: 8em; display: inline-block; font-family: monospace; }
+</style>`
+
+var htmlIndex = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Nutcracker Apparatus Viewer</title>` + htmlStyle + `</head>
+<body><main>
+<h1>Apparatus Viewer</h1>
+<p>{{.Summary.Lemmata}} lemmata, {{.Summary.Witnesses}} witnesses, {{.Summary.Conjectures}} corrections and conjectures, {{.Summary.Warnings}} warnings.</p>
+<h2>Chapters</h2>
+<ul>{{range .Chapters}}<li><a href="{{.File}}">{{.ID}}</a> ({{.Lemmata}} lemmata)</li>{{end}}</ul>
+<h2>Witness presence</h2>
+{{range .Timelines}}<div><span class="witness">{{.Witness}}</span><div class="timeline">{{range .Cells}}<a href="{{.Chapter}}#{{.Anchor}}" title="{{.URN}}"{{if not .Present}} class="absent"{{end}}></a>{{end}}</div></div>
+{{end}}
+{{if .Warnings}}<h2>Warnings</h2><ul>{{range .Warnings}}<li>{{.}}</li>{{end}}</ul>{{end}}
+</main></body></html>
+`))
+
+var htmlChapterPage = template.Must(template.New("chapter").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Chapter {{.Chapter.ID}}</title>` + htmlStyle + `</head>
+<body><main>
+<nav><a href="index.html">Index</a>{{if .Previous}} | <a href="{{.Previous.File}}">&larr; {{.Previous.ID}}</a>{{end}}{{if .Next}} | <a href="{{.Next.File}}">{{.Next.ID}} &rarr;</a>{{end}}</nav>
+<h1>Chapter {{.Chapter.ID}}</h1>
+<div class="text">{{range .Chapter.Passages}}<span class="lemma{{if .Variant}} variant{{end}}" id="{{.Anchor}}" title="{{.URN}}" onclick="show(this)">{{.Base}}</span>
+<div class="app" id="app-{{.Anchor}}">
+<h2>{{.URN}}</h2>
+<p>{{.Base}}</p>
+<table>
+<tr><th>Witness</th><th>Reading</th><th></th></tr>
+{{range .Readings}}<tr{{if eq .Status "absent"}} class="absent"{{end}}><td>{{.Witness}}{{if .Group}} ({{.Group}}){{end}}</td><td>{{.Reading}}</td><td class="status">{{.Status}}{{if .Equivalence}} (= {{.Equivalence}}){{end}}</td></tr>
+{{end}}</table>
+{{if .Conjectures}}<h3>Corrections and conjectures</h3>
+<table>{{range .Conjectures}}<tr><td>{{.Witness}}</td><td>{{.Reading}}</td></tr>{{end}}</table>{{end}}
+</div>{{end}}</div>
+</main>
+<aside id="panel"><p>Click a lemma to see its apparatus.</p></aside>
+<script>
+function show(el) {
+  document.querySelectorAll(".lemma.selected").forEach(function (s) { s.classList.remove("selected"); });
+  el.classList.add("selected");
+  document.getElementById("panel").innerHTML = document.getElementById("app-" + el.id).innerHTML;
+}
+if (location.hash) {
+  var el = document.getElementById(location.hash.substring(1));
+  if (el) { show(el); }
+}
+</script>
+</body></html>
+`))
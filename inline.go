@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// Inline is a node of the inline content of a reading: plain text or an
+// element such as gap, unclear, supplied, del, add or hi with its children.
+type Inline struct {
+	Kind     string     `json:"kind"`
+	Text     string     `json:"text,omitempty"`
+	Attrs    []xml.Attr `json:"-"`
+	Children []Inline   `json:"children,omitempty"`
+}
+
+// markerElements carry no text of their own and are dropped when rendering.
+var markerElements = map[string]bool{
+	"witStart":    true,
+	"witEnd":      true,
+	"lacunaStart": true,
+	"lacunaEnd":   true,
+}
+
+var unknownMarkup = make(map[string]bool)
+
+// parseInline parses the inner XML of a reading into inline nodes.
+func parseInline(inner string) []Inline {
+	decoder := xml.NewDecoder(strings.NewReader("<r>" + inner + "</r>"))
+	stack := [][]Inline{nil}
+	kinds := []string{}
+	attrs := [][]xml.Attr{}
+	for {
+		t, err := decoder.Token()
+		if t == nil || err != nil {
+			break
+		}
+		switch se := t.(type) {
+		case xml.StartElement:
+			stack = append(stack, nil)
+			kinds = append(kinds, se.Name.Local)
+			attrs = append(attrs, se.Attr)
+		case xml.EndElement:
+			if len(kinds) == 0 {
+				break
+			}
+			node := Inline{Kind: kinds[len(kinds)-1], Attrs: attrs[len(attrs)-1], Children: stack[len(stack)-1]}
+			stack = stack[:len(stack)-1]
+			kinds = kinds[:len(kinds)-1]
+			attrs = attrs[:len(attrs)-1]
+			if len(kinds) == 0 {
+				// closing the wrapper
+				return node.Children
+			}
+			stack[len(stack)-1] = append(stack[len(stack)-1], node)
+		case xml.CharData:
+			stack[len(stack)-1] = append(stack[len(stack)-1], Inline{Kind: "text", Text: string(se)})
+		}
+	}
+	return stack[0]
+}
+
+// hasMarkup tells whether the nodes contain anything but plain text and
+// marker elements.
+func hasMarkup(nodes []Inline) bool {
+	for _, node := range nodes {
+		if node.Kind != "text" && !markerElements[node.Kind] {
+			return true
+		}
+	}
+	return false
+}
+
+// renderInline renders the nodes as plain text, framing each element with
+// the brackets configured for its kind. Gaps lose their content, notes are
+// not part of the text at all.
+func renderInline(nodes []Inline) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		switch {
+		case node.Kind == "text":
+			b.WriteString(node.Text)
+		case markerElements[node.Kind], node.Kind == "note":
+		default:
+			brackets, ok := config.Markup[node.Kind]
+			if !ok && !unknownMarkup[node.Kind] {
+				unknownMarkup[node.Kind] = true
+				warn("no bracket convention for <" + node.Kind + "> inside readings, keeping its text only")
+			}
+			b.WriteString(brackets[0])
+			if node.Kind != "gap" {
+				b.WriteString(renderInline(node.Children))
+			}
+			b.WriteString(brackets[1])
+		}
+	}
+	return b.String()
+}
+
+// renderInlineTEI writes the nodes back as TEI.
+func renderInlineTEI(nodes []Inline) string {
+	var b strings.Builder
+	for _, node := range nodes {
+		if node.Kind == "text" {
+			xml.EscapeText(&b, []byte(node.Text))
+			continue
+		}
+		b.WriteString("<" + node.Kind)
+		for _, attr := range node.Attrs {
+			name := attr.Name.Local
+			if attr.Name.Space == "xml" || attr.Name.Space == "http://www.w3.org/XML/1998/namespace" {
+				name = "xml:" + name
+			}
+			b.WriteString(" " + name + `="`)
+			xml.EscapeText(&b, []byte(attr.Value))
+			b.WriteString(`"`)
+		}
+		if len(node.Children) == 0 {
+			b.WriteString("/>")
+			continue
+		}
+		b.WriteString(">")
+		b.WriteString(renderInlineTEI(node.Children))
+		b.WriteString("</" + node.Kind + ">")
+	}
+	return b.String()
+}
+
+// readingTEI returns the TEI of a reading that contains markup.
+func readingTEI(passageURN, witness string) string {
+	nodes, ok := readingMarkup[passageURN][witness]
+	if !ok {
+		return ""
+	}
+	return renderInlineTEI(nodes)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const defaultInput = "2020_02_19_Collation_NBh 3.xml"
+
+var inputList = flag.String("input", defaultInput, "comma-separated list of collation files, read in order")
+var manifestFile = flag.String("manifest", "", "file listing the collation files, one per line, read in order")
+
+// inputFile is the collation file being parsed; witnessFiles records where
+// each witness and group was first defined.
+var inputFile = ""
+var witnessFiles = make(map[string]string)
+var inputs []string
+
+// inputFiles returns the collation files from -manifest, or else -input.
+// Paths in the manifest are relative to the manifest.
+func inputFiles() []string {
+	if inputs != nil {
+		return inputs
+	}
+	if *manifestFile == "" {
+		for _, v := range strings.Split(*inputList, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				inputs = append(inputs, v)
+			}
+		}
+		return inputs
+	}
+	f, err := os.Open(*manifestFile)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	dir := filepath.Dir(*manifestFile)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(dir, line)
+		}
+		inputs = append(inputs, line)
+	}
+	if len(inputs) == 0 {
+		log.Fatalln(*manifestFile + ": no input files")
+	}
+	return inputs
+}
+
+// sourceFile names the current file in reading sources once there is more
+// than one input, and is empty otherwise.
+func sourceFile() string {
+	if len(inputFiles()) < 2 {
+		return ""
+	}
+	return inputFile
+}
+
+func inFile(file string) string {
+	if file == "" {
+		return ""
+	}
+	return " in " + file
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// latexSeries maps the CTE app@type layers to reledmac footnote series.
+var latexSeries = map[string]string{
+	"a1": "A",
+	"a2": "B",
+	"a3": "C",
+	"a4": "D",
+	"a5": "E",
+	"a6": "F",
+}
+
+var latexSeriesOrder = []string{"A", "B", "C", "D", "E", "F"}
+
+var latexEscaper = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`#`, `\#`,
+	`$`, `\$`,
+	`%`, `\%`,
+	`&`, `\&`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+var siglumMarkers = []string{"2pc", "pc", "ac", "vl", "Note"}
+
+func latexEscape(s string) string {
+	return latexEscaper.Replace(s)
+}
+
+// latexSiglum prints the correction markers of a resolved siglum such as
+// M12_pc as superscripts and drops the remaining underscores.
+func latexSiglum(siglum string) string {
+	parts := strings.Split(siglum, "_")
+	name := ""
+	for _, part := range parts {
+		marker := false
+		for _, v := range siglumMarkers {
+			if part == v {
+				marker = true
+			}
+		}
+		if marker {
+			name = name + `\textsuperscript{` + latexEscape(part) + `}`
+		} else {
+			name = name + latexEscape(part)
+		}
+	}
+	return name
+}
+
+func latexReading(reading string) string {
+	if reading == config.Markers.Omitted {
+		return `\emph{om.}`
+	}
+	return latexEscape(normaliseSpace(reading))
+}
+
+type latexEntry struct {
+	Reading string
+	Sigla   []string
+	Groups  []string
+}
+
+// latexApparatus groups the variant readings of one passage by series and
+// shared text. Readings agreeing with the base, also by equivalence, are left out.
+func latexApparatus(passage PassageReport, conjectures []ReadingReport) map[string][]*latexEntry {
+	apparatus := make(map[string][]*latexEntry)
+	add := func(reading ReadingReport) {
+		series, ok := latexSeries[layerMap[passage.URN][reading.Witness]]
+		if !ok {
+			series = "A"
+		}
+		text := normaliseSpace(reading.Reading)
+		// ac readings are stored under the bare siglum, their witDetail
+		// is kept as the rule of the source
+		siglum := reading.Witness
+		if source := readingSources[passage.URN][reading.Witness]; source.Kind == "witDetail" && source.Rule == "ac" {
+			siglum = siglum + "_ac"
+		}
+		for _, entry := range apparatus[series] {
+			if entry.Reading == text {
+				entry.Sigla = append(entry.Sigla, siglum)
+				entry.Groups = append(entry.Groups, reading.Group)
+				return
+			}
+		}
+		apparatus[series] = append(apparatus[series], &latexEntry{Reading: text, Sigla: []string{siglum}, Groups: []string{reading.Group}})
+	}
+	for _, reading := range passage.Readings {
+		if reading.Status != "reading" || reading.Equivalence != "" {
+			continue
+		}
+		if normaliseSpace(reading.Reading) == normaliseSpace(passage.Base) {
+			continue
+		}
+		add(reading)
+	}
+	for _, reading := range conjectures {
+		add(reading)
+	}
+	return apparatus
+}
+
+func latexLemma(base string) string {
+	words := strings.Fields(base)
+	if len(words) <= 4 {
+		return ""
+	}
+	return `\lemma{` + latexEscape(words[0]) + ` \ldots{} ` + latexEscape(words[len(words)-1]) + `}`
+}
+
+// latexEdition is the LaTeX file while the report is written, with the
+// chapter whose section is open.
+type latexEdition struct {
+	f       *os.File
+	chapter string
+}
+
+func startLaTeX(filename string) *latexEdition {
+	log.Println("writing", filename+"...")
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	f.WriteString("% Generated by nutcracker; compile with xelatex or lualatex.\n")
+	f.WriteString("\\documentclass{article}\n")
+	f.WriteString("\\usepackage{fontspec}\n")
+	f.WriteString("\\setmainfont{Noto Serif}\n")
+	f.WriteString("\\usepackage{reledmac}\n")
+	f.WriteString("\\newseries{F}\n")
+	f.WriteString("\\begin{document}\n\n")
+	f.WriteString("\\beginnumbering\n")
+	return &latexEdition{f: f}
+}
+
+func writeLaTeXPassages(edition *latexEdition, passages []PassageReport, conjectures map[string][]ReadingReport) {
+	f := edition.f
+	for _, passage := range passages {
+		chapter := chapterOf(passage.URN)
+		if chapter != edition.chapter {
+			if edition.chapter != "" {
+				f.WriteString("\n\\pend\n")
+			}
+			f.WriteString("\n\\eledsection{" + latexEscape(chapter) + "}\n\n")
+			f.WriteString("\\pstart\n")
+			edition.chapter = chapter
+		}
+		f.WriteString("% " + passage.URN + "\n")
+		apparatus := latexApparatus(passage, conjectures[passage.URN])
+		if len(apparatus) == 0 || strings.TrimSpace(passage.Base) == "" {
+			f.WriteString(latexEscape(normaliseSpace(passage.Base)))
+			f.WriteString("\n")
+			continue
+		}
+		f.WriteString("\\edtext{" + latexEscape(normaliseSpace(passage.Base)) + "}{")
+		f.WriteString(latexLemma(passage.Base))
+		for _, series := range latexSeriesOrder {
+			entries := apparatus[series]
+			if len(entries) == 0 {
+				continue
+			}
+			notes := []string{}
+			for _, entry := range entries {
+				sort.Strings(entry.Sigla)
+				sigla := []string{}
+				for _, siglum := range groupSigla(entry.Sigla, entry.Groups) {
+					sigla = append(sigla, latexSiglum(siglum))
+				}
+				notes = append(notes, latexReading(entry.Reading)+" "+strings.Join(sigla, " "))
+			}
+			f.WriteString("\\" + series + "footnote{" + strings.Join(notes, "; ") + "}")
+		}
+		f.WriteString("}\n")
+	}
+}
+
+func finishLaTeX(edition *latexEdition) {
+	f := edition.f
+	defer f.Close()
+	if edition.chapter != "" {
+		f.WriteString("\n\\pend\n")
+	}
+	f.WriteString("\\endnumbering\n\n")
+	f.WriteString("\\end{document}\n")
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const alignmentModel = "urn:cite2:cite:datamodels.v1:alignment"
+const alignsVerb = "urn:cite2:cite:verbs.v1:aligns"
+
+// alignmentProperties are the properties the alignment datamodel requires
+// of its collections.
+var alignmentProperties = []string{"urn", "label", "description", "editor", "date"}
+
+// propertyURN returns the URN of a property of a collection.
+func propertyURN(collection, property string) string {
+	return strings.TrimSuffix(collection, ":") + "." + property + ":"
+}
+
+// checkDuplicates reports URNs defined more than once, which Brucheion
+// rejects.
+func checkDuplicates(cex CEX) []CEXError {
+	errors := []CEXError{}
+	// keyed by block and URN without joining them, which would copy every
+	// URN of the file
+	seen := make(map[[2]string]int)
+	check := func(block, urn string, line int) {
+		if first, ok := seen[[2]string{block, urn}]; ok {
+			errors = append(errors, CEXError{Block: block, Line: line, Msg: "duplicate " + urn + ", first at line " + strconv.Itoa(first)})
+			return
+		}
+		seen[[2]string{block, urn}] = line
+	}
+	for _, entry := range cex.Catalog {
+		check("#!ctscatalog", entry.URN, entry.Line)
+	}
+	for _, node := range cex.Nodes {
+		check("#!ctsdata", node.URN, node.Line)
+	}
+	for _, model := range cex.DataModels {
+		check("#!datamodels", model.Collection+"#"+model.Model, model.Line)
+	}
+	for _, collection := range cex.Collections {
+		check("#!citecollections", collection.URN, collection.Line)
+	}
+	for _, property := range cex.Properties {
+		check("#!citeproperties", property.URN, property.Line)
+	}
+	for _, data := range cex.Data {
+		for _, record := range data.Records {
+			check("#!citedata", record.Fields[0], record.Line)
+		}
+	}
+	relations := make(map[Relation]int)
+	for _, relation := range cex.Relations {
+		key := Relation{Subject: relation.Subject, Verb: relation.Verb, Object: relation.Object}
+		if first, ok := relations[key]; ok {
+			errors = append(errors, CEXError{Block: "#!relations", Line: relation.Line, Msg: "duplicate " + relation.Subject + "#" + relation.Verb + "#" + relation.Object + ", first at line " + strconv.Itoa(first)})
+			continue
+		}
+		relations[key] = relation.Line
+	}
+	return errors
+}
+
+// checkProperties reports citedata columns that are not declared as
+// properties of their collection.
+func checkProperties(cex CEX) []CEXError {
+	errors := []CEXError{}
+	properties := make(map[string]bool)
+	for _, property := range cex.Properties {
+		properties[property.URN] = true
+	}
+	for _, data := range cex.Data {
+		if len(data.Records) == 0 {
+			continue
+		}
+		collection := workOf(data.Records[0].Fields[0])
+		for _, column := range data.Header {
+			if !properties[propertyURN(collection, column)] {
+				errors = append(errors, CEXError{Block: "#!citedata", Line: data.HeaderLine, Msg: "column " + column + " is not a declared property of " + collection})
+			}
+		}
+	}
+	return errors
+}
+
+// checkAlignmentModel checks the collections declared with the alignment
+// datamodel: they need its properties, every alignment needs at least one
+// aligns relation, and alignments align CTS passages only.
+func checkAlignmentModel(cex CEX) []CEXError {
+	errors := []CEXError{}
+	collections := make(map[string]bool)
+	for _, collection := range cex.Collections {
+		collections[collection.URN] = true
+	}
+	properties := make(map[string]bool)
+	for _, property := range cex.Properties {
+		properties[property.URN] = true
+	}
+	aligned := make(map[string]bool)
+	for _, model := range cex.DataModels {
+		if model.Model != alignmentModel {
+			continue
+		}
+		if !collections[model.Collection] {
+			errors = append(errors, CEXError{Block: "#!datamodels", Line: model.Line, Msg: "alignment collection " + model.Collection + " is not declared"})
+		}
+		for _, property := range alignmentProperties {
+			if !properties[propertyURN(model.Collection, property)] {
+				errors = append(errors, CEXError{Block: "#!datamodels", Line: model.Line, Msg: "alignment collection " + model.Collection + " lacks the property " + property})
+			}
+		}
+		aligned[model.Collection] = true
+	}
+	relations := make(map[string]int)
+	for _, relation := range cex.Relations {
+		if relation.Verb != alignsVerb {
+			continue
+		}
+		relations[relation.Subject]++
+		if !aligned[workOf(relation.Subject)] {
+			errors = append(errors, CEXError{Block: "#!relations", Line: relation.Line, Msg: relation.Subject + " aligns passages but its collection does not follow the alignment datamodel"})
+		}
+		if !strings.HasPrefix(relation.Object, "urn:cts:") {
+			errors = append(errors, CEXError{Block: "#!relations", Line: relation.Line, Msg: "alignment " + relation.Subject + " aligns " + relation.Object + ", which is not a CTS passage"})
+		}
+	}
+	for _, data := range cex.Data {
+		for _, record := range data.Records {
+			if aligned[workOf(record.Fields[0])] && relations[record.Fields[0]] == 0 {
+				errors = append(errors, CEXError{Block: "#!citedata", Line: record.Line, Msg: "alignment " + record.Fields[0] + " aligns nothing"})
+			}
+		}
+	}
+	return errors
+}
+
+// lint checks CEX files against the rules Brucheion relies on and exits 1
+// if any of them has a problem.
+func lint(args []string) {
+	if len(args) < 1 {
+		log.Fatalln("usage: nutcracker lint <file.cex>...")
+	}
+	problems := 0
+	for _, filename := range args {
+		f, err := os.Open(filename)
+		if err != nil {
+			panic(err)
+		}
+		cex, errors := parseCEX(f)
+		f.Close()
+		errors = append(errors, checkCEX(cex)...)
+		sort.SliceStable(errors, func(i, j int) bool {
+			return errors[i].Line < errors[j].Line
+		})
+		for _, e := range errors {
+			fmt.Println(filename+":", e)
+		}
+		problems += len(errors)
+	}
+	log.Println(problems, "problems.")
+	if problems > 0 {
+		os.Exit(1)
+	}
+}
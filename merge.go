@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+var mergeFile = flag.String("merge", "", "previous CEX whose manually edited alignments are kept")
+var mergeReport = flag.String("merge-report", "merge.txt", "write the report of a -merge to this file")
+
+// MergeConflict is a manually edited alignment of the previous CEX that
+// could not be kept as it was, or that was kept over a changed lemma.
+type MergeConflict struct {
+	Alignment string
+	Reason    string
+	Missing   []string
+}
+
+// mergedAlignments replace the generated alignment of their lemma;
+// addedAlignments were added by hand and are written after the others.
+var mergedAlignments = make(map[string]Alignment)
+var addedAlignments = []Alignment{}
+
+var mergeConflicts = []MergeConflict{}
+var mergeKept = []string{}
+var mergeRegenerated = 0
+
+// previousTokens returns, by lemma, the URNs nutcracker aligns in a CEX:
+// the tokens of the token exemplars, the subreferences of the passage-level
+// versions when its alignments use them, or else the passages themselves.
+func previousTokens(cex CEX) map[string]map[string]bool {
+	subref := false
+	for _, relation := range cex.Relations {
+		if relation.Verb == alignsVerb && strings.Contains(relation.Object, "@") {
+			subref = true
+		}
+	}
+	exemplars := false
+	for _, node := range cex.Nodes {
+		if strings.HasSuffix(workOf(node.URN), ".token:") {
+			exemplars = true
+		}
+	}
+	tokens := make(map[string]map[string]bool)
+	add := func(lemma, urn string) {
+		if tokens[lemma] == nil {
+			tokens[lemma] = make(map[string]bool)
+		}
+		tokens[lemma][urn] = true
+	}
+	for _, node := range cex.Nodes {
+		work := workOf(node.URN)
+		token := strings.HasSuffix(work, ".token:")
+		switch {
+		case subref && !token:
+			lemma := strings.TrimPrefix(node.URN, work)
+			if markerStatus(node.Text) != "" {
+				add(lemma, node.URN)
+				continue
+			}
+			words := customSplit(node.Text)
+			for n := range words {
+				add(lemma, node.URN+"@"+subreference(words, n+1))
+			}
+		case !subref && token == exemplars:
+			add(passageKey(node.URN), node.URN)
+		}
+	}
+	return tokens
+}
+
+func sameSet(a, b map[string]bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// citedPassage returns the passage a URN cites, without its subreference.
+func citedPassage(urn string) string {
+	if i := strings.Index(urn, "@"); i >= 0 {
+		return urn[:i]
+	}
+	return urn
+}
+
+// readsDifferently tells whether any of the aligned passages, or the
+// passage a subreference is cut from, reads otherwise than in the previous
+// CEX.
+func readsDifferently(urns []string, previousTexts, currentTexts map[string]string) bool {
+	for _, urn := range urns {
+		passage := citedPassage(urn)
+		if text, ok := previousTexts[passage]; ok && currentTexts[passage] != text {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeAlignments reads the previous CEX and keeps its manually edited
+// alignments wherever every passage they align still exists. Alignments
+// that still match what was generated for their lemma are regenerated.
+func mergeAlignments(filename string) {
+	log.Println("merging alignments from", filename+"...")
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	previous, errors := parseCEX(f)
+	f.Close()
+	for _, e := range errors {
+		log.Println(filename+":", e)
+	}
+
+	generated := previousTokens(previous)
+	relations := make(map[string]map[string]bool)
+	order := make(map[string][]string)
+	for _, relation := range previous.Relations {
+		if relation.Verb != alignsVerb {
+			continue
+		}
+		if relations[relation.Subject] == nil {
+			relations[relation.Subject] = make(map[string]bool)
+		}
+		if !relations[relation.Subject][relation.Object] {
+			order[relation.Subject] = append(order[relation.Subject], relation.Object)
+		}
+		relations[relation.Subject][relation.Object] = true
+	}
+	records := make(map[string][]string)
+	for _, data := range previous.Data {
+		for _, record := range data.Records {
+			if relations[record.Fields[0]] != nil && len(record.Fields) == 5 {
+				records[record.Fields[0]] = record.Fields[1:]
+			}
+		}
+	}
+
+	// only the passages the previous alignments point to are looked up
+	// in the new editions, lemma by lemma, with the passages they are cut
+	// from, whose texts are compared with the previous CEX
+	resolvable := make(map[string]bool)
+	previousTexts := make(map[string]string)
+	currentTexts := make(map[string]string)
+	for _, node := range previous.Nodes {
+		previousTexts[node.URN] = node.Text
+	}
+	for _, objects := range relations {
+		for urn := range objects {
+			resolvable[urn] = false
+			if _, ok := previousTexts[citedPassage(urn)]; ok {
+				currentTexts[citedPassage(urn)] = ""
+			}
+		}
+	}
+	current := make(map[string]int)
+	for i, keyStr := range passageURNs {
+		current[config.Alignments.Collection+keyStr] = i
+		for _, witkey := range editionWitnesses() {
+			text := editionText(witkey, i)
+			passages := alignedPassages(witkey, keyStr, text)
+			for _, editionURN := range editionURNs(witkey) {
+				passages = append(passages, editionPassages(editionURN, witkey, keyStr, text)...)
+			}
+			for _, passage := range passages {
+				if _, ok := resolvable[passage.ID]; ok {
+					resolvable[passage.ID] = true
+				}
+				if _, ok := currentTexts[passage.ID]; ok {
+					currentTexts[passage.ID] = passage.Passage
+				}
+			}
+		}
+	}
+
+	ids := []string{}
+	for id := range relations {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return compareURN(passageKey(ids[i]), passageKey(ids[j])) < 0
+	})
+	for _, id := range ids {
+		lemma := strings.TrimPrefix(id, config.Alignments.Collection)
+		if strings.HasPrefix(id, config.Alignments.Collection) && sameSet(relations[id], generated[lemma]) {
+			mergeRegenerated++
+			continue
+		}
+		missing := []string{}
+		for _, urn := range order[id] {
+			if !resolvable[urn] {
+				missing = append(missing, urn)
+			}
+		}
+		if len(missing) > 0 {
+			reason := "aligns passages that no longer exist, dropped"
+			if _, ok := current[id]; ok {
+				reason = "aligns passages that no longer exist, regenerated"
+			}
+			mergeConflicts = append(mergeConflicts, MergeConflict{Alignment: id, Reason: reason, Missing: missing})
+			continue
+		}
+		kept := Alignment{ID: id, Previous: records[id]}
+		for _, urn := range order[id] {
+			kept.Token = append(kept.Token, CTSPassage{ID: urn})
+		}
+		// without its citedata row the alignment is labelled like a
+		// generated one, after the lemma its id names
+		if kept.Previous == nil {
+			key := passageKey(id)
+			kept.Label = alignmentLabel(key, passageText(key))
+			mergeConflicts = append(mergeConflicts, MergeConflict{Alignment: id, Reason: "kept, but it had no citedata record and was labelled anew"})
+		}
+		i, ok := current[id]
+		if !ok {
+			if readsDifferently(order[id], previousTexts, currentTexts) {
+				mergeConflicts = append(mergeConflicts, MergeConflict{Alignment: id, Reason: "kept, but the passages it aligns changed in the new collation"})
+			}
+			addedAlignments = append(addedAlignments, kept)
+			mergeKept = append(mergeKept, id)
+			continue
+		}
+		generatedAlignment := lemmaAlignment(i)
+		if kept.Previous == nil {
+			kept.Label = generatedAlignment.Label
+		}
+		newTokens := make(map[string]bool)
+		for _, token := range generatedAlignment.Token {
+			newTokens[token.ID] = true
+		}
+		if !sameSet(generated[lemma], newTokens) || readsDifferently(order[id], previousTexts, currentTexts) {
+			mergeConflicts = append(mergeConflicts, MergeConflict{Alignment: id, Reason: "kept, but the lemma changed in the new collation"})
+		}
+		mergedAlignments[id] = kept
+		mergeKept = append(mergeKept, id)
+	}
+	log.Println("kept", len(mergeKept), "edited alignments, regenerated", mergeRegenerated, "untouched ones,", len(mergeConflicts), "conflicts")
+}
+
+func writeMergeReport(filename string) {
+	log.Println("writing", filename+"...")
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	f.WriteString("### Merge with " + *mergeFile + " ###\n\n")
+	f.WriteString(fmt.Sprintln("Regenerated untouched alignments:", mergeRegenerated))
+	f.WriteString(fmt.Sprintln("Kept edited alignments:", len(mergeKept)))
+	for _, id := range mergeKept {
+		f.WriteString(fmt.Sprintln("  ", id))
+	}
+	f.WriteString("\n")
+	f.WriteString("!!! Conflicts !!!\n")
+	for _, conflict := range mergeConflicts {
+		f.WriteString("---------------------------------------------\n")
+		f.WriteString(fmt.Sprintln("Alignment:", conflict.Alignment))
+		f.WriteString(fmt.Sprintln("Reason:", conflict.Reason))
+		for _, urn := range conflict.Missing {
+			f.WriteString(fmt.Sprintln("Missing:", urn))
+		}
+	}
+}
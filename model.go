@@ -0,0 +1,198 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+)
+
+var dumpFile = flag.String("dump", "", "write the parsed collation as a JSON model to this file")
+var modelFile = flag.String("model", "", "read the collation from a JSON model written by -dump instead of the XML")
+
+// modelVersion is the version of the JSON model; it changes whenever a
+// field changes its meaning or is removed.
+const modelVersion = 1
+
+// Model is the parsed collation as written by -dump and read by -model:
+// everything the writers need, after witness ranges were overridden and the
+// collation validated. See "Collation model" in the README for the schema.
+type Model struct {
+	Version    int                     `json:"version"`
+	Provenance []Provenance            `json:"provenance"`
+	Witnesses  []ModelWitness          `json:"witnesses"`
+	Groups     map[string]WitnessGroup `json:"groups,omitempty"`
+	Chapters   []ModelChapter          `json:"chapters"`
+	Readings   []ModelReading          `json:"readings"`
+	Notes      []Note                  `json:"notes"`
+	Warnings   []string                `json:"warnings"`
+}
+
+// ModelWitness is a witness id or a derived key such as M10_pc with the
+// siglum it resolves to. Witnesses of <listWit> also have the resolution of
+// their raw siglum and their catalogue record.
+type ModelWitness struct {
+	ID         string           `json:"id"`
+	Siglum     string           `json:"siglum"`
+	Resolution *SiglumTransform `json:"resolution,omitempty"`
+	Manuscript *Manuscript      `json:"manuscript,omitempty"`
+	File       string           `json:"file,omitempty"`
+}
+
+type ModelChapter struct {
+	Reference string         `json:"reference"`
+	Passages  []ModelPassage `json:"passages"`
+}
+
+// ModelPassage is a lemma of the base text with the presence of every
+// witness there. Anchor is missing for the text after the last anchor.
+type ModelPassage struct {
+	URN      string          `json:"urn"`
+	Base     string          `json:"base"`
+	Anchor   *ReadingSource  `json:"anchor,omitempty"`
+	Presence map[string]bool `json:"presence"`
+}
+
+// ModelReading is the explicit reading of a witness at a passage. Witness is
+// the resolved siglum, Correction marks readings of the correction layers
+// (pc, vl outside a6) and TEI keeps the inline markup of the reading.
+type ModelReading struct {
+	Passage    string        `json:"passage"`
+	Witness    string        `json:"witness"`
+	Reading    string        `json:"reading"`
+	Layer      string        `json:"layer"`
+	Correction bool          `json:"correction,omitempty"`
+	TEI        string        `json:"tei,omitempty"`
+	Source     ReadingSource `json:"source"`
+}
+
+func modelReadings(positions map[string]map[string]string, correction bool) []ModelReading {
+	readings := []ModelReading{}
+	for _, passageURN := range passageKeys(positions) {
+		for _, witkey := range sortedStringKeys(positions[passageURN]) {
+			readings = append(readings, ModelReading{
+				Passage:    passageURN,
+				Witness:    witkey,
+				Reading:    positions[passageURN][witkey],
+				Layer:      layerMap[passageURN][witkey],
+				Correction: correction,
+				TEI:        readingTEI(passageURN, witkey),
+				Source:     readingSources[passageURN][witkey],
+			})
+		}
+	}
+	return readings
+}
+
+func buildModel() Model {
+	model := Model{Version: modelVersion, Provenance: provenance(), Groups: witnessGroups, Notes: notes, Warnings: warnings}
+	for _, k := range sortedStringKeys(siglaMap) {
+		witness := ModelWitness{ID: k, Siglum: siglaMap[k], Resolution: siglumTransforms[k], File: witnessFiles[k]}
+		if ms, ok := manuscripts[k]; ok {
+			witness.Manuscript = &ms
+		}
+		model.Witnesses = append(model.Witnesses, witness)
+	}
+	for i, passageURN := range passageURNs {
+		passage := ModelPassage{URN: passageURN, Base: basetext[i], Presence: witnessRange[passageURN]}
+		if anchor, ok := anchorLocations[passageURN]; ok {
+			passage.Anchor = &anchor
+		}
+		chapter := chapterOf(passageURN)
+		if len(model.Chapters) == 0 || model.Chapters[len(model.Chapters)-1].Reference != chapter {
+			model.Chapters = append(model.Chapters, ModelChapter{Reference: chapter})
+		}
+		model.Chapters[len(model.Chapters)-1].Passages = append(model.Chapters[len(model.Chapters)-1].Passages, passage)
+	}
+	model.Readings = append(modelReadings(positionMap, false), modelReadings(secPositionMap, true)...)
+	return model
+}
+
+func writeModel(filename string) {
+	log.Println("writing", filename+"...")
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(buildModel()); err != nil {
+		panic(err)
+	}
+}
+
+// loadModel fills the parser's tables from a model instead of the XML. The
+// model is not validated again; its warnings are taken over.
+func loadModel(filename string) {
+	log.Println("reading model", filename+"...")
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	var model Model
+	if err := json.NewDecoder(f).Decode(&model); err != nil {
+		log.Fatalln(filename+":", err)
+	}
+	if model.Version != modelVersion {
+		log.Fatalln(filename+": model version", model.Version, "is not supported, expected", modelVersion)
+	}
+	provenanceRecords = model.Provenance
+	for _, witness := range model.Witnesses {
+		siglaMap[witness.ID] = witness.Siglum
+		if witness.Resolution != nil {
+			siglumTransforms[witness.ID] = witness.Resolution
+		}
+		if witness.Manuscript != nil {
+			manuscripts[witness.ID] = *witness.Manuscript
+		}
+		if witness.File != "" {
+			witnessFiles[witness.ID] = witness.File
+		}
+	}
+	for id, group := range model.Groups {
+		witnessGroups[id] = group
+	}
+	for _, chapter := range model.Chapters {
+		for _, passage := range chapter.Passages {
+			passageURNs = append(passageURNs, passage.URN)
+			basetext = append(basetext, passage.Base)
+			witnessRange[passage.URN] = make(map[string]bool)
+			for witness, present := range passage.Presence {
+				witnessRange[passage.URN][witness] = present
+			}
+			if passage.Anchor != nil {
+				anchorLocations[passage.URN] = *passage.Anchor
+			}
+		}
+	}
+	for _, reading := range model.Readings {
+		positions, witnesses := positionMap, witnessMap
+		if reading.Correction {
+			positions, witnesses = secPositionMap, secWitnessMap
+		}
+		if len(positions[reading.Passage]) == 0 {
+			positions[reading.Passage] = make(map[string]string)
+		}
+		positions[reading.Passage][reading.Witness] = reading.Reading
+		witnesses[reading.Witness] = true
+		if len(layerMap[reading.Passage]) == 0 {
+			layerMap[reading.Passage] = make(map[string]string)
+		}
+		layerMap[reading.Passage][reading.Witness] = reading.Layer
+		if len(readingSources[reading.Passage]) == 0 {
+			readingSources[reading.Passage] = make(map[string]ReadingSource)
+		}
+		readingSources[reading.Passage][reading.Witness] = reading.Source
+		if reading.TEI != "" {
+			if len(readingMarkup[reading.Passage]) == 0 {
+				readingMarkup[reading.Passage] = make(map[string][]Inline)
+			}
+			readingMarkup[reading.Passage][reading.Witness] = parseInline(reading.TEI)
+		}
+	}
+	notes = append(notes, model.Notes...)
+	warnings = append(warnings, model.Warnings...)
+	log.Println("Loaded", len(passageURNs), "passages and", len(model.Readings), "readings.")
+}
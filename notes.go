@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type NoteData struct {
+	Attrs []xml.Attr `xml:",any,attr"`
+	Inner string     `xml:",innerxml"`
+}
+
+// Note is an editorial note anchored in the base text. Token is the number
+// of base tokens of the passage preceding the note; 0 means the note was
+// found inside the <app> and annotates the whole passage.
+type Note struct {
+	ID      string            `json:"id"`
+	Passage string            `json:"passage"`
+	Token   int               `json:"token"`
+	Attrs   map[string]string `json:"attributes,omitempty"`
+	Text    string            `json:"text"`
+	File    string            `json:"file,omitempty"`
+	Line    int               `json:"line,omitempty"`
+}
+
+var notes = []Note{}
+var notesPerPassage = make(map[string]int)
+
+const notesCollection = "urn:cite2:ducat:notes.temp:"
+
+func storeNote(passageURN string, token int, data NoteData, line int) {
+	notesPerPassage[passageURN]++
+	note := Note{
+		ID:      notesCollection + passageURN + "_" + strconv.Itoa(notesPerPassage[passageURN]),
+		Passage: passageURN,
+		Token:   token,
+		Attrs:   make(map[string]string),
+		Text:    normaliseSpace(renderInline(parseInline(data.Inner))),
+		File:    sourceFile(),
+		Line:    line,
+	}
+	for _, attr := range data.Attrs {
+		note.Attrs[attr.Name.Local] = attr.Value
+	}
+	notes = append(notes, note)
+}
+
+// readingNotes returns the notes inside the inner XML of a reading; like
+// the notes directly inside the <app> they annotate the whole passage.
+func readingNotes(inner string) []NoteData {
+	found := []NoteData{}
+	if !strings.Contains(inner, "<note") {
+		return found
+	}
+	var walk func(nodes []Inline)
+	walk = func(nodes []Inline) {
+		for _, node := range nodes {
+			if node.Kind == "note" {
+				found = append(found, NoteData{Attrs: node.Attrs, Inner: renderInlineTEI(node.Children)})
+				continue
+			}
+			walk(node.Children)
+		}
+	}
+	walk(parseInline(inner))
+	return found
+}
+
+// passageIndex maps a passage to its position in passageURNs. It is built
+// on first use, once the collation is loaded and selected, and again if the
+// passages changed since.
+var passageIndex map[string]int
+var indexedPassages int
+
+func passageText(passageURN string) string {
+	if passageIndex == nil || indexedPassages != len(passageURNs) {
+		passageIndex = make(map[string]int, len(passageURNs))
+		for i, urn := range passageURNs {
+			passageIndex[urn] = i
+		}
+		indexedPassages = len(passageURNs)
+	}
+	if i, ok := passageIndex[passageURN]; ok {
+		return basetext[i]
+	}
+	return ""
+}
+
+// noteTarget returns the base text token URN a note refers to, or the token
+// range of the whole passage for notes inside an <app>.
+func noteTarget(note Note) string {
+	if !citesTokens() {
+		return versionURN("DFG") + note.Passage
+	}
+	text := passageText(note.Passage)
+	tokens := len(customSplit(text))
+	if note.Token > 0 && note.Token <= tokens {
+		return tokenURN("DFG", note.Passage, text, note.Token)
+	}
+	if tokens <= 1 {
+		return tokenURN("DFG", note.Passage, text, 1)
+	}
+	return rangeURN(tokenURN("DFG", note.Passage, text, 1), tokenURN("DFG", note.Passage, text, tokens))
+}
+
+func noteAttributes(note Note) string {
+	attrs := []string{}
+	for _, k := range sortedStringKeys(note.Attrs) {
+		attrs = append(attrs, k+"="+note.Attrs[k])
+	}
+	return strings.Join(attrs, " ")
+}
+
+func cexField(s string) string {
+	return strings.Replace(normaliseSpace(s), "#", "＃", -1)
+}
+
+func writeNotesCEX(f *bufio.Writer) {
+	f.WriteString("#!citedata\n")
+	f.WriteString("urn#label#passage#type#place#attributes#text\n")
+	for _, note := range notes {
+		f.WriteString(note.ID)
+		f.WriteString("#")
+		f.WriteString("Note on " + note.Passage)
+		f.WriteString("#")
+		f.WriteString(noteTarget(note))
+		f.WriteString("#")
+		f.WriteString(cexField(note.Attrs["type"]))
+		f.WriteString("#")
+		f.WriteString(cexField(note.Attrs["place"]))
+		f.WriteString("#")
+		f.WriteString(cexField(noteAttributes(note)))
+		f.WriteString("#")
+		f.WriteString(cexField(note.Text))
+		f.WriteString("\n")
+	}
+	f.WriteString("\n")
+}
+
+func writeNotesReport(filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	f.WriteString("### Editorial Notes ###\n\n")
+	for _, note := range notes {
+		f.WriteString("---------------------------------------------\n")
+		f.WriteString(fmt.Sprintln("Note:", note.ID))
+		f.WriteString(fmt.Sprintln("Passage:", noteTarget(note), "(line", strconv.Itoa(note.Line)+inFile(note.File)+")"))
+		if len(note.Attrs) > 0 {
+			f.WriteString(fmt.Sprintln("Attributes:", noteAttributes(note)))
+		}
+		f.WriteString(fmt.Sprintln(note.Text))
+	}
+	f.WriteString(fmt.Sprintln("\nNotes:", len(notes)))
+}
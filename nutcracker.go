@@ -1,11 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"encoding/xml"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -18,6 +22,9 @@ type WitnessList struct {
 type WitnessMeta struct {
 	ID      string   `xml:"sameAs,attr"`
 	Abbrevs []Abbrev `xml:"abbr"`
+	Inner   string   `xml:",innerxml"`
+	// Members are the nested witnesses of a group.
+	Members []WitnessMeta `xml:"witness"`
 }
 
 type Abbrev struct {
@@ -38,22 +45,19 @@ type Anchor struct {
 	ID string `xml:"id,attr"`
 }
 
-type AppData2 struct {
-	Type  string `xml:"type,attr"`
-	Inner string `xml:",innerxml"`
-}
-
 type AppData struct {
 	Type       string      `xml:"type,attr"`
 	ToAnchor   string      `xml:"to,attr"`
 	Variant    []Variants  `xml:"rdg"`
 	WitDetails []WitDetail `xml:"witDetail"`
+	Notes      []NoteData  `xml:"note"`
+	Inner      string      `xml:",innerxml"`
 }
 
 type Variants struct {
 	VariantWitnesses string `xml:"wit,attr"`
 	VariantID        string `xml:"id,attr"`
-	VariantText      string `xml:",chardata"`
+	VariantXML       string `xml:",innerxml"`
 }
 
 type WitDetail struct {
@@ -70,11 +74,25 @@ type Witness struct {
 type Alignment struct {
 	ID    string
 	Token []CTSPassage
+	Label string
+	// Previous holds the label, description, editor and date of an
+	// alignment kept from the CEX given to -merge.
+	Previous []string
+}
+
+// ReadingStatus types a witness reading that is one of the markers.
+type ReadingStatus struct {
+	ID      string
+	Passage string
+	Witness string
+	Status  string
 }
 
 type CTSPassage struct {
 	ID      string
 	Passage string
+	// Parent is the passage-level passage a token belongs to.
+	Parent string
 }
 
 var allowedDetail = []string{"ac", "pc"}
@@ -135,481 +153,558 @@ func customSplit(passage string) (tokens []string) {
 	return (tokens)
 }
 
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// compareURN orders passage components such as 3.1.1.12 numerically
+// part by part, falling back to string comparison for other parts.
+func compareURN(a, b string) int {
+	partsA := strings.Split(a, ".")
+	partsB := strings.Split(b, ".")
+	for i := 0; i < len(partsA) && i < len(partsB); i++ {
+		numA, errA := strconv.Atoi(partsA[i])
+		numB, errB := strconv.Atoi(partsB[i])
+		switch {
+		case errA == nil && errB == nil && numA != numB:
+			if numA < numB {
+				return -1
+			}
+			return 1
+		case (errA != nil || errB != nil) && partsA[i] != partsB[i]:
+			return strings.Compare(partsA[i], partsB[i])
+		}
+	}
+	return len(partsA) - len(partsB)
+}
+
 const passageBase = "urn:cts:sktlit:skt0001.nyaya002."
 
-var editionsMap = make(map[string][]CTSPassage)
 var witnessMap = make(map[string]bool)
 var secWitnessMap = make(map[string]bool)
 var siglaMap = make(map[string]string)
-var alignments = []Alignment{}
+var readingStatuses = []ReadingStatus{}
 
 var witnessRange = make(map[string]map[string]bool)
 var witBool = make(map[string]bool)
-
-func establishWit() {
-	log.Println("Establish Witness Range...")
-	currentMilestone := ""
-	actualText := false
-	appIsOpen := false
-	witsearch := regexp.MustCompile(`#M\d+[^\s,"]`)
-	bytexml, err := os.Open("2020_02_19_Collation_NBh 3.xml")
-	if err != nil {
-		panic(err)
-	}
-	defer bytexml.Close()
-	currentChapter := "prelim"
-	decoder := xml.NewDecoder(bytexml)
-	for {
-		t, _ := decoder.Token()
-		if t == nil {
+var witsearch = regexp.MustCompile(`#M\d+[^\s,"]`)
+
+// witnessMarkers returns the witnesses whose rdg carries a witStart or witEnd
+// marker in the raw inner XML of an app.
+func witnessMarkers(inner, marker string) []string {
+	found := []string{}
+	indparts := strings.Split(inner, marker)
+	for i, v := range indparts {
+		if i == len(indparts)-1 {
 			break
 		}
-		switch se := t.(type) {
-		case xml.StartElement:
-			switch se.Name.Local {
-			case "milestone":
-				var milestone Milestone
-				decoder.DecodeElement(&milestone, &se)
-				if milestone.Type == "chapter" && milestone.Type != currentMilestone {
-					currentChapter = milestone.ID
-					actualText = true
-				}
-			case "anchor":
-				if !actualText {
-					// break
-				}
-				witnessRange[currentChapter] = make(map[string]bool)
-				for k, v := range witBool {
-					witnessRange[currentChapter][k] = v
-				}
-				appIsOpen = false
-			case "app":
-				if !appIsOpen {
-					if currentChapter == "prelim" {
-						currentChapter = "3.1.1"
-					}
-				}
-				appIsOpen = true
-				var appdata AppData2
-				decoder.DecodeElement(&appdata, &se)
-				if appdata.Type != "a1" {
-					break
-				}
-				switch {
-				case strings.Contains(appdata.Inner, "witStart"):
-					log.Println("Witness start at", currentChapter)
-					indparts := strings.Split(appdata.Inner, "witStart")
-					for i, v := range indparts {
-						if i == len(indparts)-1 {
-							break
-						}
-						witsl := strings.Split(v, "rdg")
-						for _, v2 := range witsl {
-							witstrs := witsearch.FindAllString(v2, -1)
-							for _, v3 := range witstrs {
-								newwit := strings.Replace(v3, "#", "", -1)
-								witBool[newwit] = true
-							}
-						}
-					}
-				case strings.Contains(appdata.Inner, "witEnd"):
-					log.Println("Witness end at", currentChapter)
-					indparts := strings.Split(appdata.Inner, "witEnd")
-					for i, v := range indparts {
-						if i == len(indparts)-1 {
-							break
-						}
-						witsl := strings.Split(v, "rdg")
-						for _, v2 := range witsl {
-							witstrs := witsearch.FindAllString(v2, -1)
-							for _, v3 := range witstrs {
-								newwit := strings.Replace(v3, "#", "", -1)
-								witBool[newwit] = false
-							}
-						}
-					}
-				default:
-					break
+		witsl := strings.Split(v, "rdg")
+		for _, v2 := range witsl {
+			witstrs := witsearch.FindAllString(v2, -1)
+			for _, v3 := range witstrs {
+				found = append(found, strings.Replace(v3, "#", "", -1))
+			}
+			if groupsearch != nil {
+				for _, v3 := range groupsearch.FindAllStringSubmatch(v2, -1) {
+					members, _ := expandWitness(v3[1])
+					found = append(found, members...)
 				}
 			}
 		}
 	}
-	log.Println("Done.")
+	return found
+}
+
+// snapshotWitnesses records the presence of every witness at passageURN.
+// Witnesses ending at this lemma are still present here and drop out after it.
+func snapshotWitnesses(passageURN string, ending []string) {
+	witnessRange[passageURN] = make(map[string]bool)
+	for k, v := range witBool {
+		witnessRange[passageURN][k] = v
+	}
+	for _, wit := range ending {
+		witnessRange[passageURN][wit] = true
+		witBool[wit] = false
+	}
+}
+
+var configFile = flag.String("config", "nutcracker.json", "configuration file")
+var rangesFile = flag.String("ranges", "", "witness presence overrides (CSV or JSON)")
+var equivFile = flag.String("equiv", "equivalences.txt", "orthographic equivalence rules")
+var htmlDir = flag.String("html", "", "write a static HTML apparatus viewer to this directory")
+var latexFile = flag.String("latex", "", "write a reledmac critical edition to this file")
+var presenceCSV = flag.String("presence-csv", "", "write the witness × lemma presence matrix to this CSV file")
+var notesFile = flag.String("notes", "notes.txt", "write the editorial notes report to this file")
+var presenceTxt = flag.String("presence-summary", "", "write the run-length witness presence summary to this file")
+
+var positionMap = map[string]map[string]string{}
+var secPositionMap = map[string]map[string]string{}
+var basetext = []string{}
+var passageURNs = []string{}
+var warnings = []string{}
+
+func warn(v ...interface{}) {
+	msg := strings.TrimSpace(fmt.Sprintln(v...))
+	log.Println("Warning:", msg)
+	warnings = append(warnings, msg)
 }
 
 func main() {
-	establishWit()
-	bytexml, err := os.Open("2020_02_19_Collation_NBh 3.xml")
-	// 2020_02_06_Collation_NBh3.xml
-	if err != nil {
-		panic(err)
+	flag.Parse()
+	loadConfig(*configFile)
+	loadEquivalences(*equivFile)
+	switch flag.Arg(0) {
+	case "explain":
+		explain(flag.Args()[1:])
+		return
+	case "lint":
+		lint(flag.Args()[1:])
+		return
+	case "validate":
+		loadCollation()
+		log.Println(len(warnings), "warnings.")
+		if len(warnings) > 0 {
+			os.Exit(1)
+		}
+		return
 	}
-	defer bytexml.Close()
-	report, err := os.Create("report.txt")
-	if err != nil {
-		panic(err)
+	loadCollation()
+	selectCollation()
+	if *dumpFile != "" {
+		writeModel(*dumpFile)
 	}
-	defer report.Close()
-	if err != nil {
-		log.Println(err)
-		os.Exit(1)
+	log.Println("writing report and output.cex...")
+	writeReports()
+	log.Println("Parsed", len(passageURNs), "lemmata...")
+	if *mergeFile != "" {
+		mergeAlignments(*mergeFile)
+		writeMergeReport(*mergeReport)
+	}
+	writeCEX()
+	if *checkOutput {
+		checkRoundTrip("output.cex")
+	}
+	if *notesFile != "" {
+		writeNotesReport(*notesFile)
+	}
+	if *presenceCSV != "" {
+		writePresenceCSV(*presenceCSV)
+	}
+	if *presenceTxt != "" {
+		writePresenceSummary(*presenceTxt)
+	}
+}
+
+// loadCollation parses the collation, applies the witness range overrides
+// and validates the result. A model given with -model replaces the parsing
+// and validation.
+func loadCollation() {
+	if *modelFile != "" {
+		loadModel(*modelFile)
+		buildInverseSigla()
+		if *rangesFile != "" {
+			loadRangeOverrides(*rangesFile)
+			applyRangeOverrides()
+		}
+		return
 	}
-	var positionMap = map[string]map[string]string{}
-	var secPositionMap = map[string]map[string]string{}
+	parseCollation()
+	buildInverseSigla()
+	if *rangesFile != "" {
+		loadRangeOverrides(*rangesFile)
+		applyRangeOverrides()
+	}
+	validate()
+}
+
+func parseCollation() {
+	loadGroups()
+	compileGroupSearch()
 	lemmaCount := 1
-	basetext := []string{}
-	passageURNs := []string{}
 	passageURN := "start"
 	currentMilestone := ""
-	actualText := false
-	appIsOpen := false
 	appURN := ""
 
 	currentChapter := "prelim"
-	decoder := xml.NewDecoder(bytexml)
-	bodyOpen := false
-	noteOpen := false
+	citationValues := []string{}
+	if config.Citation.FrontMatter == "cite" {
+		currentChapter = config.Citation.FrontMatterReference
+	}
 	passageBuffer := ""
-	spaceReg := regexp.MustCompile(`\s+`)
+	witEnding := []string{}
+	// closePassage makes the text since the last anchor the next passage
+	closePassage := func() {
+		passageURN = currentChapter + "." + fmt.Sprintf("%d", lemmaCount)
+		basetext = append(basetext, passageBuffer)
+		passageURNs = append(passageURNs, passageURN)
+		snapshotWitnesses(passageURN, witEnding)
+		witEnding = nil
+		passageBuffer = ""
+		lemmaCount++
+	}
 
 	// flushed := false
 
-	for {
-		t, _ := decoder.Token()
-		if t == nil {
-			break
+	// The input files are read as one document: numbering and witness
+	// ranges carry over to the next file, the text after the last anchor
+	// of a file closes it.
+	for _, filename := range inputFiles() {
+		log.Println("reading", filename+"...")
+		bytexml, err := os.Open(filename)
+		if err != nil {
+			panic(err)
 		}
-		switch se := t.(type) {
-		case xml.EndElement:
-			switch se.Name.Local {
-			case "note":
-				noteOpen = false
-			case "body":
-				bodyOpen = false
+		inputFile = filename
+		actualText := config.Citation.FrontMatter == "cite"
+		appIsOpen := false
+		decoder := xml.NewDecoder(bytexml)
+		bodyOpen := false
+		noteOpen := false
+		for {
+			t, _ := decoder.Token()
+			if t == nil {
 				break
 			}
-		case xml.StartElement:
-			switch se.Name.Local {
-			case "listWit":
-				if bodyOpen == false {
-					witlist := WitnessList{}
-					decoder.DecodeElement(&witlist, &se)
-					for _, v := range witlist.Witness {
-						key := strings.TrimSpace(v.ID)
-						value := []string{}
-						for _, v2 := range v.Abbrevs {
-							firstid := v2.Name
-							resolution := []string{}
-							firstid = strings.ReplaceAll(firstid, "^!", "_Note")
-							firstid = strings.ReplaceAll(firstid, "(", "")
-							firstid = strings.ReplaceAll(firstid, ")", "")
-							firstid = strings.TrimSpace(firstid)
-							firstid = spaceReg.ReplaceAllString(firstid, "_")
-							// You almost cannot see the difference, but there is one!!
-							// firstid = strings.ReplaceAll(firstid, " ", "")
-							firstid = strings.ReplaceAll(firstid, " ", "")
-							if firstid != "" {
-								value = append(value, firstid)
-							}
-							resolution = append(resolution, firstid)
-							for _, v3 := range v2.Extensions {
-								secondid := v3.Name
-								secondid = strings.ReplaceAll(secondid, "^!", "Note")
-								secondid = strings.ReplaceAll(secondid, "(", "")
-								secondid = strings.ReplaceAll(secondid, ")", "")
-								secondid = strings.TrimSpace(secondid)
-								secondid = spaceReg.ReplaceAllString(secondid, "_")
-								secondid = strings.ReplaceAll(secondid, " _", "_")
-								if secondid != "" {
-									value = append(value, secondid)
-								}
+			switch se := t.(type) {
+			case xml.EndElement:
+				switch se.Name.Local {
+				case "note":
+					noteOpen = false
+				case "body":
+					bodyOpen = false
+					break
+				}
+			case xml.StartElement:
+				switch se.Name.Local {
+				case "listWit":
+					if bodyOpen == false {
+						witlist := WitnessList{}
+						decoder.DecodeElement(&witlist, &se)
+						for _, v := range witlist.Witness {
+							registerWitness(v)
+						}
+						compileGroupSearch()
+					}
+				case "milestone":
+					var milestone Milestone
+					decoder.DecodeElement(&milestone, &se)
+					if level := citationLevel(milestone.Type); level >= 0 && milestone.Type != currentMilestone {
+						citationValues = citeMilestone(citationValues, level, milestone.ID)
+						// cited front matter without an anchor of its own ends here
+						if currentChapter == config.Citation.FrontMatterReference && config.Citation.FrontMatter == "cite" && anyLetters(passageBuffer) {
+							closePassage()
+						}
+						// a chapter continued from a previous file keeps
+						// counting; the text after the last anchor of the
+						// previous one closes it
+						if reference := citationReference(citationValues); reference != currentChapter {
+							if anyLetters(passageBuffer) {
+								closePassage()
 							}
+							currentChapter = reference
+							lemmaCount = 1
 						}
-						if key != "" {
-							siglaMap[key] = strings.Join(value, "_")
+						actualText = true
+					}
+				case "anchor":
+					if !actualText {
+						break
+					}
+					anchorLine, anchorColumn := decoder.InputPos()
+					var anchor Anchor
+					decoder.DecodeElement(&anchor, &se)
+
+					closePassage()
+					anchorLocations[passageURN] = ReadingSource{Kind: "anchor", Rule: anchor.ID, File: sourceFile(), Line: anchorLine, Column: anchorColumn}
+					// flushed = true
+					appIsOpen = false
+				case "app":
+					if !appIsOpen {
+						if currentChapter == "prelim" {
+							currentChapter = config.Citation.FrontMatterReference
 						}
+						appURN = currentChapter + "." + fmt.Sprintf("%d", lemmaCount)
 					}
-				}
-			case "milestone":
-				var milestone Milestone
-				decoder.DecodeElement(&milestone, &se)
-				if milestone.Type == "chapter" && milestone.Type != currentMilestone {
-					currentChapter = milestone.ID
-					lemmaCount = 1
-					actualText = true
-				}
-			case "anchor":
-				if !actualText {
-					break
-				}
-				var anchor Anchor
-				decoder.DecodeElement(&anchor, &se)
-
-				passageURN = currentChapter + "." + fmt.Sprintf("%d", lemmaCount)
-				basetext = append(basetext, passageBuffer)
-				passageURNs = append(passageURNs, passageURN)
-				passageBuffer = ""
-				// flushed = true
-				lemmaCount++
-				appIsOpen = false
-			case "app":
-				if !appIsOpen {
-					if currentChapter == "prelim" {
-						currentChapter = "3.1.1"
+					appIsOpen = true
+					appLine, appColumn := decoder.InputPos()
+					var appdata AppData
+					decoder.DecodeElement(&appdata, &se)
+					rdgSource := ReadingSource{Kind: "reading", Rule: "rdg", Layer: appdata.Type, File: sourceFile(), Line: appLine, Column: appColumn}
+					for _, note := range appdata.Notes {
+						storeNote(appURN, 0, note, appLine)
 					}
-					appURN = currentChapter + "." + fmt.Sprintf("%d", lemmaCount)
-				}
-				appIsOpen = true
-				var appdata AppData
-				decoder.DecodeElement(&appdata, &se)
-				for _, variant := range appdata.Variant {
-					witNames := strings.Split(variant.VariantWitnesses, " ")
-					switch {
-					case variant.VariantID != "":
-						for _, witDetails := range appdata.WitDetails {
-							if witDetails.Target != variant.VariantID {
-								continue
-							}
-							witDetStr := witDetails.Wit
-							witDetStr = strings.Replace(witDetStr, " ", "", -1)
-							witDetStr = strings.Replace(witDetStr, "#", "", -1)
-							witDetStr = strings.Replace(witDetStr, "\n", "", -1)
-							detail := strings.TrimSpace(witDetails.Detail)
-							switch detail {
-							case "pc":
-								newkey := strings.Join([]string{witDetStr, detail}, "_")
-								newvalue := strings.Join([]string{siglaMap[witDetStr], detail}, "_")
-								siglaMap[newkey] = newvalue
-								readingvalue := variant.VariantText
-								readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-								if strings.TrimSpace(readingvalue) == "" {
-									readingvalue = "[[om.]]"
-								}
-								if len(secPositionMap[appURN]) == 0 {
-									secPositionMap[appURN] = make(map[string]string)
+					for _, variant := range appdata.Variant {
+						for _, note := range readingNotes(variant.VariantXML) {
+							storeNote(appURN, 0, note, appLine)
+						}
+					}
+					appRecords = append(appRecords, AppRecord{URN: appURN, To: appdata.ToAnchor, BeforeText: !actualText, Source: rdgSource})
+					if appdata.Type == "a1" {
+						for _, wit := range witnessMarkers(appdata.Inner, "witStart") {
+							log.Println("Witness", wit, "starts at", appURN)
+							witBool[wit] = true
+							witnessEvents = append(witnessEvents, WitnessEvent{Witness: wit, Kind: "witStart", Passage: appURN, Source: rdgSource})
+						}
+						for _, wit := range witnessMarkers(appdata.Inner, "witEnd") {
+							log.Println("Witness", wit, "ends at", appURN)
+							witEnding = append(witEnding, wit)
+							witnessEvents = append(witnessEvents, WitnessEvent{Witness: wit, Kind: "witEnd", Passage: appURN, Source: rdgSource})
+						}
+					}
+					for _, variant := range appdata.Variant {
+						witNames := strings.Split(variant.VariantWitnesses, " ")
+						switch {
+						case variant.VariantID != "":
+							for _, witDetails := range appdata.WitDetails {
+								if witDetails.Target != variant.VariantID {
+									continue
 								}
-								secWitnessMap[newvalue] = true
-								secPositionMap[appURN][newvalue] = readingvalue
-							case "vl":
-								switch appdata.Type {
-								case "a6":
-									newkey := strings.Join([]string{witDetStr, detail}, "_")
-									newvalue := strings.Join([]string{siglaMap[witDetStr], detail}, "_")
-									siglaMap[newkey] = newvalue
-									readingvalue := variant.VariantText
-									readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-									if strings.TrimSpace(readingvalue) == "" {
-										readingvalue = "[[om.]]"
-									}
-									if len(positionMap[appURN]) == 0 {
-										positionMap[appURN] = make(map[string]string)
-									}
-									witnessMap[newvalue] = true
-									positionMap[appURN][newvalue] = readingvalue
-								default:
-									newkey := strings.Join([]string{witDetStr, detail}, "_")
-									newvalue := strings.Join([]string{siglaMap[witDetStr], detail}, "_")
-									siglaMap[newkey] = newvalue
-									readingvalue := variant.VariantText
-									readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-									if strings.TrimSpace(readingvalue) == "" {
-										readingvalue = "[[om.]]"
+								witDetStr := witDetails.Wit
+								witDetStr = strings.Replace(witDetStr, " ", "", -1)
+								witDetStr = strings.Replace(witDetStr, "#", "", -1)
+								witDetStr = strings.Replace(witDetStr, "\n", "", -1)
+								detail := strings.TrimSpace(witDetails.Detail)
+								detailSource := ReadingSource{Kind: "witDetail", Rule: detail, Layer: appdata.Type, File: sourceFile(), Line: appLine, Column: appColumn}
+								members, group := expandWitness(witDetStr)
+								detailSource.Group = group
+								for _, witDetStr := range members {
+									switch detail {
+									case "pc":
+										newkey := strings.Join([]string{witDetStr, detail}, "_")
+										newvalue := strings.Join([]string{resolveSiglum(witDetStr), detail}, "_")
+										siglaMap[newkey] = newvalue
+										storeReading(secPositionMap, secWitnessMap, appURN, newvalue, variant.VariantXML, appdata.Type, detailSource)
+									case "vl":
+										newkey := strings.Join([]string{witDetStr, detail}, "_")
+										newvalue := strings.Join([]string{resolveSiglum(witDetStr), detail}, "_")
+										siglaMap[newkey] = newvalue
+										switch appdata.Type {
+										case "a6":
+											storeReading(positionMap, witnessMap, appURN, newvalue, variant.VariantXML, appdata.Type, detailSource)
+										default:
+											storeReading(secPositionMap, secWitnessMap, appURN, newvalue, variant.VariantXML, appdata.Type, detailSource)
+										}
+									default:
+										if strings.Contains(detail, "pc") {
+											newkey := strings.Join([]string{witDetStr, "2pc"}, "_")
+											newvalue := strings.Join([]string{resolveSiglum(witDetStr), "2pc"}, "_")
+											siglaMap[newkey] = newvalue
+											storeReading(positionMap, witnessMap, appURN, newvalue, variant.VariantXML, appdata.Type, detailSource)
+										} else {
+											// still save without addon
+											storeReading(positionMap, witnessMap, appURN, resolveSiglum(witDetStr), variant.VariantXML, appdata.Type, detailSource)
+										}
 									}
-									if len(secPositionMap[appURN]) == 0 {
-										secPositionMap[appURN] = make(map[string]string)
-									}
-									secWitnessMap[newvalue] = true
-									secPositionMap[appURN][newvalue] = readingvalue
 								}
-							default:
-								if strings.Contains(detail, "pc") {
-									newkey := strings.Join([]string{witDetStr, "2pc"}, "_")
-									newvalue := strings.Join([]string{siglaMap[witDetStr], "2pc"}, "_")
-									siglaMap[newkey] = newvalue
-									readingvalue := variant.VariantText
-									readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-									if strings.TrimSpace(readingvalue) == "" {
-										readingvalue = "[[om.]]"
-									}
-									if len(positionMap[appURN]) == 0 {
-										positionMap[appURN] = make(map[string]string)
-									}
-									witnessMap[newvalue] = true
-									positionMap[appURN][newvalue] = readingvalue
-								} else {
-									// still save without addon
-									readingvalue := variant.VariantText
-									readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-									if strings.TrimSpace(readingvalue) == "" {
-										readingvalue = "[[om.]]"
-									}
-									if len(positionMap[appURN]) == 0 {
-										positionMap[appURN] = make(map[string]string)
-									}
-									resolSigl := siglaMap[witDetStr]
-									witnessMap[resolSigl] = true
-									positionMap[appURN][resolSigl] = readingvalue
+							}
+						default:
+							for _, witname := range witNames {
+								witDetStr := witname
+								witDetStr = strings.Replace(witDetStr, " ", "", -1)
+								witDetStr = strings.Replace(witDetStr, "#", "", -1)
+								witDetStr = strings.Replace(witDetStr, "\n", "", -1)
+								members, group := expandWitness(witDetStr)
+								source := rdgSource
+								source.Group = group
+								for _, member := range members {
+									storeReading(positionMap, witnessMap, appURN, resolveSiglum(member), variant.VariantXML, appdata.Type, source)
 								}
 							}
+
 						}
-					default:
-						for _, witname := range witNames {
-							witDetStr := witname
-							witDetStr = strings.Replace(witDetStr, " ", "", -1)
-							witDetStr = strings.Replace(witDetStr, "#", "", -1)
-							witDetStr = strings.Replace(witDetStr, "\n", "", -1)
-							readingvalue := variant.VariantText
-							readingvalue = strings.Replace(readingvalue, "\n", "", -1)
-							if strings.TrimSpace(readingvalue) == "" {
-								readingvalue = "[[om.]]"
-							}
-							if len(positionMap[appURN]) == 0 {
-								positionMap[appURN] = make(map[string]string)
-							}
-							resolSigl := siglaMap[witDetStr]
-							witnessMap[resolSigl] = true
-							positionMap[appURN][resolSigl] = readingvalue
+					}
+				case "note":
+					if bodyOpen && actualText {
+						noteLine, _ := decoder.InputPos()
+						var note NoteData
+						decoder.DecodeElement(&note, &se)
+						tokenCount := 0
+						if anyLetters(passageBuffer) {
+							tokenCount = len(customSplit(passageBuffer))
 						}
-
+						storeNote(currentChapter+"."+fmt.Sprintf("%d", lemmaCount), tokenCount, note, noteLine)
+						break
 					}
+					noteOpen = true
+				case "body":
+					bodyOpen = true
+				}
+			case xml.CharData:
+				if !actualText {
+					break
+				}
+				if bodyOpen && !noteOpen {
+					// flushed = false
+					outputString := string(se)
+					outputString = strings.Replace(outputString, "\n", " ", -1)
+					passageBuffer = passageBuffer + outputString
+					// outputString = strings.TrimSpace(outputString)
+					// if outputString != "" {
+					// }
 				}
-			case "note":
-				noteOpen = true
-			case "body":
-				bodyOpen = true
-			}
-		case xml.CharData:
-			if !actualText {
-				break
-			}
-			if bodyOpen && !noteOpen {
-				// flushed = false
-				outputString := string(se)
-				outputString = strings.Replace(outputString, "\n", " ", -1)
-				passageBuffer = passageBuffer + outputString
-				// outputString = strings.TrimSpace(outputString)
-				// if outputString != "" {
-				// }
 			}
 		}
+		bytexml.Close()
+		// the text after the last anchor of a file closes it
+		if anyLetters(passageBuffer) {
+			closePassage()
+		}
 	}
+}
 
-	basetext = append(basetext, passageBuffer)
-	numID := lemmaCount + 1
-	passageURN = currentChapter + "." + fmt.Sprintf("%d", numID)
-	passageURNs = append(passageURNs, passageURN)
-	lemmaCount++
+// layerMap records the app@type of every reading stored by storeReading,
+// readingMarkup the inline model of readings containing markup.
+var layerMap = map[string]map[string]string{}
+var readingMarkup = map[string]map[string][]Inline{}
 
-	log.Println("writing report and output.cex...")
-	noteExtract := regexp.MustCompile(`_Note[^_]+`)
-
-	report.WriteString("### Sigla Abbreviations ###\n\n")
-	inverseSiglaMap := make(map[string]string)
-	for k, v := range siglaMap {
-		inverseSiglaMap[v] = k
-		report.WriteString(fmt.Sprintln("key:", k, "value:", v))
-	}
-	report.WriteString("\n\n")
-	report.WriteString("### Readings & Variants ###\n\n")
-	for key, value := range basetext {
-		keyStr := passageURNs[key]
-		report.WriteString("---------------------------------------------")
-		report.WriteString("\n")
-		alignmentID := "urn:cite2:ducat:alignments.temp:" + keyStr
-		editionURN := passageBase + "DFG.token:"
-		tmpalignment := Alignment{ID: alignmentID}
-		for index, element := range customSplit(value) {
-			idPassage := editionURN + keyStr + "_" + strconv.Itoa(index+1)
-			passages := editionsMap[editionURN]
-			tmpPassage := CTSPassage{ID: idPassage, Passage: element}
-			passages = append(passages, tmpPassage)
-			tmpalignment.Token = append(tmpalignment.Token, tmpPassage)
-			editionsMap[editionURN] = passages
+func storeReading(positions map[string]map[string]string, witnesses map[string]bool, appURN, witness, inner, layer string, source ReadingSource) {
+	nodes := parseInline(inner)
+	readingvalue := strings.Replace(renderInline(nodes), "\n", "", -1)
+	if strings.TrimSpace(readingvalue) == "" {
+		readingvalue = config.Markers.Omitted
+	}
+	if len(positions[appURN]) == 0 {
+		positions[appURN] = make(map[string]string)
+	}
+	if previous, ok := positions[appURN][witness]; ok {
+		duplicateReadings = append(duplicateReadings, DuplicateReading{
+			Passage:  appURN,
+			Witness:  witness,
+			Readings: [2]string{previous, readingvalue},
+			Sources:  [2]ReadingSource{readingSources[appURN][witness], source},
+		})
+	}
+	witnesses[witness] = true
+	positions[appURN][witness] = readingvalue
+	if len(layerMap[appURN]) == 0 {
+		layerMap[appURN] = make(map[string]string)
+	}
+	layerMap[appURN][witness] = layer
+	if len(readingSources[appURN]) == 0 {
+		readingSources[appURN] = make(map[string]ReadingSource)
+	}
+	readingSources[appURN][witness] = source
+	if hasMarkup(nodes) {
+		if len(readingMarkup[appURN]) == 0 {
+			readingMarkup[appURN] = make(map[string][]Inline)
 		}
-		report.WriteString(fmt.Sprintln("Position: ", keyStr, "Reading:", value))
-		report.WriteString("\n")
-
-		report.WriteString("Variants:")
-		report.WriteString("\n")
-		for witkey := range witnessMap {
-			witnessURN := passageBase + witkey + ".token:"
-			reading, ok := positionMap[keyStr][witkey]
-			if !ok {
-				newkey := strings.Join(strings.Split(keyStr, ".")[:len(strings.Split(keyStr, "."))-1], ".")
-				there := witnessRange[newkey][inverseSiglaMap[witkey]]
-				if !there {
-					newkey2 := strings.Join(strings.Split(witkey, "_")[:len(strings.Split(witkey, "_"))-1], "_")
-					there = witnessRange[newkey][inverseSiglaMap[newkey2]]
-				}
-				if !there {
-					newkey2 := noteExtract.ReplaceAllString(witkey, "")
-					there = witnessRange[newkey][inverseSiglaMap[newkey2]]
-				}
-				if !there {
-					newkey2 := noteExtract.ReplaceAllString(witkey, "")
-					newkey2 = strings.Join(strings.Split(newkey2, "_")[:len(strings.Split(newkey2, "_"))-1], "_")
-					there = witnessRange[newkey][inverseSiglaMap[newkey2]]
-				}
-				if there {
-					reading = value
-				} else {
-					reading = "[[NA]]"
-				}
-			}
-			for index, element := range customSplit(reading) {
-				idPassage := witnessURN + keyStr + "_" + strconv.Itoa(index+1)
-				passages := editionsMap[witnessURN]
-				tmpPassage := CTSPassage{ID: idPassage, Passage: element}
-				passages = append(passages, tmpPassage)
-				tmpalignment.Token = append(tmpalignment.Token, tmpPassage)
-				editionsMap[witnessURN] = passages
+		readingMarkup[appURN][witness] = nodes
+	}
+}
+
+// registerWitness records the siglum and description of a witness from
+// <listWit>, or the group it forms with its nested witnesses.
+func registerWitness(v WitnessMeta) {
+	key := strings.TrimSpace(v.ID)
+	transform := resolveWitnessSiglum(v)
+	if len(v.Members) > 0 {
+		group := WitnessGroup{Label: transform.Final}
+		for _, member := range v.Members {
+			registerWitness(member)
+			group.Members = append(group.Members, strings.TrimSpace(member.ID))
+		}
+		if group.Label == "" {
+			group.Label = key
+		}
+		if _, ok := config.Groups[key]; ok {
+			warn("group", key, "is defined both in <listWit> and in the config, using the config")
+		} else if previous, ok := witnessGroups[key]; ok {
+			if strings.Join(previous.Members, " ") != strings.Join(group.Members, " ") || previous.Label != group.Label {
+				warn("group", key, "in", inputFile, "conflicts with its definition in", witnessFiles[key]+", keeping the first")
 			}
-			report.WriteString(fmt.Sprintln(witkey, "Reading:", reading))
+		} else if key != "" {
+			witnessGroups[key] = group
+			witnessFiles[key] = inputFile
 		}
-		alignments = append(alignments, tmpalignment)
+		return
 	}
-
-	report.WriteString("\n\n")
-	report.WriteString("$$$ First Passage $$$")
-
-	for _, v := range editionsMap {
-		for i := range v {
-			report.WriteString(fmt.Sprintln(v[i]))
-			break
+	if previous, ok := siglaMap[key]; ok && key != "" {
+		if previous != transform.Final {
+			warn("witness", key, "is", transform.Final, "in", inputFile, "but", previous, "in", witnessFiles[key]+", keeping", previous)
 		}
-		break
+		return
 	}
+	if key != "" {
+		witnessFiles[key] = inputFile
+		siglaMap[key] = transform.Final
+		siglumTransforms[key] = transform
+		ms := Manuscript{Witness: key, RawSiglum: normaliseSpace(transform.Raw), Siglum: siglaMap[key]}
+		describeWitness(&ms, parseInline(v.Inner))
+		manuscripts[key] = ms
+	}
+}
 
-	report.WriteString(fmt.Sprintln("Parsed", len(alignments), "lemmata..."))
-	log.Println("Parsed", len(alignments), "lemmata...")
-	log.Println(len(basetext))
-
-	report.WriteString("\n\n")
-	report.WriteString("_Witness Present?__\n")
-	for k, v := range witnessRange {
-		report.WriteString(fmt.Sprintln("Passage:", k))
-		for k2, v2 := range v {
-			report.WriteString(fmt.Sprintln("key:", k2, "key2:", siglaMap[k2], "value:", v2))
-		}
+func resolveSiglum(witDetStr string) string {
+	resolSigl, ok := siglaMap[witDetStr]
+	if !ok {
+		warn("no sigla abbreviation for witness", witDetStr)
 	}
+	return resolSigl
+}
 
-	report.WriteString("\n\n")
-	report.WriteString("+++Conjectures+++\n")
-	for k, v := range secPositionMap {
-		report.WriteString(fmt.Sprintln("Passage:", k))
-		for k2, v2 := range v {
-			report.WriteString(fmt.Sprintln("key:", k2, "value:", v2))
+// passageReport gives the reading of every witness at passage i. Marker
+// readings are added to readingStatuses and readings agreeing by
+// equivalence are counted on the way.
+func passageReport(i int) PassageReport {
+	keyStr := passageURNs[i]
+	value := basetext[i]
+	passage := PassageReport{URN: keyStr, Base: value}
+	for _, witkey := range sortedKeys(witnessMap) {
+		status := "reading"
+		reading, ok := positionMap[keyStr][witkey]
+		if ok {
+			if label, equal := equivalentReading(reading, value); equal {
+				suppressReading(keyStr, witkey, label)
+			}
 		}
+		source := readingSources[keyStr][witkey]
+		if !ok {
+			reading, source = inheritReading(keyStr, witkey, value)
+			requireReading(keyStr, witkey, source)
+			status = source.Kind
+		}
+		if markerStatus := markerStatus(reading); markerStatus != "" {
+			readingStatuses = append(readingStatuses, ReadingStatus{
+				ID:      "urn:cite2:ducat:readingstatus.temp:" + keyStr + "_" + witkey,
+				Passage: tokenURN(witkey, keyStr, reading, 1),
+				Witness: witkey,
+				Status:  markerStatus,
+			})
+		}
+		passage.Readings = append(passage.Readings, ReadingReport{
+			Witness:     witkey,
+			Reading:     reading,
+			Status:      status,
+			Equivalence: equivalenceMap[keyStr][witkey],
+			Source:      source.String(),
+			Group:       source.Group,
+			TEI:         readingTEI(keyStr, witkey),
+		})
 	}
-	writeCEX()
+	return passage
 }
 
+// writeCEX streams the CEX through a buffer. Passages, tokens and
+// alignments are generated lemma by lemma as they are written, each once
+// for #!ctsdata and once for the alignments, so only the texts of one lemma
+// and the exemplar of one witness are held at a time.
 func writeCEX() {
-	f, err := os.Create("output.cex")
+	file, err := os.Create("output.cex")
 	if err != nil {
 		panic(err)
 	}
-	defer f.Close()
+	defer file.Close()
+	f := bufio.NewWriterSize(file, 64*1024)
+	defer func() {
+		if err := f.Flush(); err != nil {
+			panic(err)
+		}
+	}()
 
 	// cexversion
 	f.WriteString("#!cexversion\n")
@@ -625,113 +720,177 @@ func writeCEX() {
 	f.WriteString("urn#citationScheme#groupName#workTitle#versionLabel#exemplarLabel#online#language")
 	f.WriteString("\n")
 
-	editionURN := passageBase + "DFG.token:"
-	f.WriteString(editionURN)
-	f.WriteString("#")
-	f.WriteString("NyayaScheme")
-	f.WriteString("#")
-	f.WriteString("GroupName")
-	f.WriteString("#")
-	f.WriteString("WorkTitle")
-	f.WriteString("#")
-	f.WriteString("VersionLabel")
-	f.WriteString("#")
-	f.WriteString("Brucheion-Tokenised")
-	f.WriteString("#")
-	f.WriteString("TRUE")
-	f.WriteString("#")
-	f.WriteString("san")
-	f.WriteString("\n")
-
-	for witkey := range witnessMap {
-		witnessURN := passageBase + witkey + ".token:"
-		f.WriteString(witnessURN)
-		f.WriteString("#")
-		f.WriteString("NyayaScheme")
-		f.WriteString("#")
-		f.WriteString("GroupName")
-		f.WriteString("#")
-		f.WriteString("WorkTitle")
-		f.WriteString("#")
-		f.WriteString("VersionLabel")
-		f.WriteString("#")
-		f.WriteString("Brucheion-Tokenised")
-		f.WriteString("#")
-		f.WriteString("TRUE")
-		f.WriteString("#")
-		f.WriteString("san")
-		f.WriteString("\n")
+	witnesses := editionWitnesses()
+	for _, witkey := range witnesses {
+		writeCatalogEntries(f, witkey)
 	}
 	f.WriteString("\n")
 	f.WriteString("#!ctsdata\n")
-
-	for _, edition := range editionsMap {
-		for passageIndex := range edition {
-			f.WriteString(edition[passageIndex].ID)
-			f.WriteString("#")
-			f.WriteString(edition[passageIndex].Passage)
-			f.WriteString("\n")
+	// the isPartOf relations of the tokens are spooled until #!relations,
+	// so that the tokens are generated once
+	var spool *os.File
+	var partOf *bufio.Writer
+	if writesTokens() && emitsLevel("passage") {
+		spool, err = os.CreateTemp("", "nutcracker-*.cex")
+		if err != nil {
+			panic(err)
 		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		partOf = bufio.NewWriterSize(spool, 64*1024)
+	}
+	for _, witkey := range witnesses {
+		writeEditionData(f, partOf, witkey)
 	}
 	f.WriteString("\n")
 
 	f.WriteString("#!datamodels\n")
 	f.WriteString("Collection#Model#Label#Description\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp:#urn:cite2:cite:datamodels.v1:alignment#Text Alignment Model#The CITE model for text alignment. See documentation at <https://eumaeus.github.io/citealign/>.\n")
+	f.WriteString(config.Alignments.Collection + "#urn:cite2:cite:datamodels.v1:alignment#Text Alignment Model#The CITE model for text alignment. See documentation at <https://eumaeus.github.io/citealign/>.\n")
 	f.WriteString("\n")
 
 	f.WriteString("#!citecollections\n")
 	f.WriteString("URN#Description#Labelling property#Ordering property#License\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp:#Citation Alignments#urn:cite2:ducat:alignments.temp.label:##CC-BY 3.0\n")
+	f.WriteString(config.Alignments.Collection + "#Citation Alignments#" + propertyURN(config.Alignments.Collection, "label") + "##CC-BY 3.0\n")
+	f.WriteString(provenanceCollection + "#Provenance#" + propertyURN(provenanceCollection, "input") + "##CC-BY 3.0\n")
+	f.WriteString("urn:cite2:ducat:readingstatus.temp:#Witness Reading Status#urn:cite2:ducat:readingstatus.temp.status:##CC-BY 3.0\n")
+	f.WriteString("urn:cite2:ducat:notes.temp:#Editorial Notes#urn:cite2:ducat:notes.temp.label:##CC-BY 3.0\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp:#Manuscript Catalogue#urn:cite2:ducat:manuscripts.temp.siglum:##CC-BY 3.0\n")
 	f.WriteString("\n")
 
 	f.WriteString("#!citeproperties\n")
 	f.WriteString("Property#Label#Type#Authority list\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp.urn:#Alignment Record#Cite2Urn#\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp.label:#Label#String#\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp.description:#Description#String#\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp.editor:#Editor#String#\n")
-	f.WriteString("urn:cite2:ducat:alignments.temp.date:#Date#String#\n")
+	f.WriteString(propertyURN(config.Alignments.Collection, "urn") + "#Alignment Record#Cite2Urn#\n")
+	f.WriteString(propertyURN(config.Alignments.Collection, "label") + "#Label#String#\n")
+	f.WriteString(propertyURN(config.Alignments.Collection, "description") + "#Description#String#\n")
+	f.WriteString(propertyURN(config.Alignments.Collection, "editor") + "#Editor#String#\n")
+	f.WriteString(propertyURN(config.Alignments.Collection, "date") + "#Date#String#\n")
+	f.WriteString("urn:cite2:ducat:readingstatus.temp.urn:#Reading Status Record#Cite2Urn#\n")
+	f.WriteString("urn:cite2:ducat:readingstatus.temp.passage:#Passage#CtsUrn#\n")
+	f.WriteString("urn:cite2:ducat:readingstatus.temp.witness:#Witness#String#\n")
+	f.WriteString("urn:cite2:ducat:readingstatus.temp.status:#Status#String#omitted,unavailable,unknown\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.urn:#Note#Cite2Urn#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.label:#Label#String#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.passage:#Annotated Passage#CtsUrn#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.type:#Type#String#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.place:#Place#String#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.attributes:#Attributes#String#\n")
+	f.WriteString("urn:cite2:ducat:notes.temp.text:#Text#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.urn:#Manuscript#Cite2Urn#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.siglum:#Siglum#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.rawSiglum:#Siglum as in the Collation#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.repository:#Repository#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.shelfmark:#Shelfmark#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.date:#Date#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.material:#Material#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.script:#Script#String#\n")
+	f.WriteString("urn:cite2:ducat:manuscripts.temp.folios:#Folios#String#\n")
+	f.WriteString(propertyURN(provenanceCollection, "urn") + "#Provenance Record#Cite2Urn#\n")
+	f.WriteString(propertyURN(provenanceCollection, "tool") + "#Tool#String#\n")
+	f.WriteString(propertyURN(provenanceCollection, "version") + "#Version#String#\n")
+	f.WriteString(propertyURN(provenanceCollection, "input") + "#Input File#String#\n")
+	f.WriteString(propertyURN(provenanceCollection, "sha256") + "#SHA-256#String#\n")
+	f.WriteString(propertyURN(provenanceCollection, "date") + "#Date#String#\n")
 	f.WriteString("\n")
 
 	f.WriteString("#!citedata\n")
 	f.WriteString("urn#label#description#editor#date\n")
-	count := 1
-	for _, alignment := range alignments {
-		// testing
-		// if alignment.ID == "urn:cite2:ducat:alignments.temp:3.1.1.50" {
-		// 	break
-		// }
-		//
-		f.WriteString(alignment.ID)
-		f.WriteString("#")
-		alignLabel := "Alignment " + strconv.Itoa(count)
-		f.WriteString(alignLabel)
+	for i, keyStr := range passageURNs {
+		alignment, ok := mergedAlignments[config.Alignments.Collection+keyStr]
+		if !ok {
+			alignment = Alignment{ID: config.Alignments.Collection + keyStr, Label: alignmentLabel(keyStr, basetext[i])}
+		}
+		writeAlignmentRecord(f, alignment)
+	}
+	for _, alignment := range addedAlignments {
+		writeAlignmentRecord(f, alignment)
+	}
+	f.WriteString("\n")
+
+	f.WriteString("#!citedata\n")
+	f.WriteString("urn#passage#witness#status\n")
+	for _, status := range readingStatuses {
+		f.WriteString(status.ID)
 		f.WriteString("#")
-		f.WriteString("Textual Alignment")
+		f.WriteString(status.Passage)
 		f.WriteString("#")
-		f.WriteString("Brucheion User")
+		f.WriteString(status.Witness)
 		f.WriteString("#")
-		f.WriteString("Sun, 19 Apr 2020 12:30:32 GMT")
+		f.WriteString(status.Status)
 		f.WriteString("\n")
-		count++
 	}
 	f.WriteString("\n")
 
+	writeNotesCEX(f)
+	writeCatalogueCEX(f)
+	writeProvenanceCEX(f)
+
 	f.WriteString("#!relations\n")
-	for _, alignment := range alignments {
-		// testing
-		// if alignment.ID == "urn:cite2:ducat:alignments.temp:3.1.1.50" {
-		// 	break
-		// }
-		//
-		for _, passage := range alignment.Token {
-			f.WriteString(alignment.ID)
-			f.WriteString("#urn:cite2:cite:verbs.v1:aligns#")
-			f.WriteString(passage.ID)
+	for i, keyStr := range passageURNs {
+		alignment, ok := mergedAlignments[config.Alignments.Collection+keyStr]
+		if !ok {
+			alignment = lemmaAlignment(i)
+		}
+		writeAlignmentRelations(f, alignment)
+	}
+	for _, alignment := range addedAlignments {
+		writeAlignmentRelations(f, alignment)
+	}
+	for _, witkey := range sortedKeys(witnessMap) {
+		ms, ok := manuscriptOf(witkey)
+		if !ok {
+			continue
+		}
+		for _, editionURN := range editionURNs(witkey) {
+			f.WriteString(editionURN)
+			f.WriteString("#urn:cite2:ducat:verbs.temp:isWitnessedBy#")
+			f.WriteString(manuscriptCollection + ms.Witness)
 			f.WriteString("\n")
 		}
 	}
+	if partOf != nil {
+		if err := partOf.Flush(); err != nil {
+			panic(err)
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			panic(err)
+		}
+		if _, err := io.Copy(f, spool); err != nil {
+			panic(err)
+		}
+	}
+	for _, note := range notes {
+		f.WriteString(note.ID)
+		f.WriteString("#urn:cite2:cite:verbs.v1:commentsOn#")
+		f.WriteString(noteTarget(note))
+		f.WriteString("\n")
+	}
+	f.WriteString("\n")
+}
+
+func writeAlignmentRecord(f *bufio.Writer, alignment Alignment) {
+	f.WriteString(alignment.ID)
+	f.WriteString("#")
+	if len(alignment.Previous) == 4 {
+		f.WriteString(strings.Join(alignment.Previous, "#"))
+		f.WriteString("\n")
+		return
+	}
+	f.WriteString(cexField(alignment.Label))
+	f.WriteString("#")
+	f.WriteString(cexField(config.Alignments.Description))
+	f.WriteString("#")
+	f.WriteString(cexField(config.Alignments.Editor))
+	f.WriteString("#")
+	f.WriteString(runTimestamp().Format(cexDate))
 	f.WriteString("\n")
+}
 
+func writeAlignmentRelations(f *bufio.Writer, alignment Alignment) {
+	for _, passage := range alignment.Token {
+		f.WriteString(alignment.ID)
+		f.WriteString("#urn:cite2:cite:verbs.v1:aligns#")
+		f.WriteString(passage.ID)
+		f.WriteString("\n")
+	}
 }
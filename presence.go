@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strings"
+)
+
+type PresenceRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type PresenceSummary struct {
+	Witness string          `json:"witness"`
+	Siglum  string          `json:"siglum"`
+	Ranges  []PresenceRange `json:"ranges"`
+}
+
+// presenceWitnesses lists every witness with a witStart or witEnd marker.
+func presenceWitnesses() []string {
+	witnesses := make(map[string]bool)
+	for _, v := range witnessRange {
+		for k := range v {
+			witnesses[k] = true
+		}
+	}
+	return sortedKeys(witnesses)
+}
+
+// presenceRanges collapses the presence of a witness at every lemma into
+// runs of consecutive lemmata. A run reaching the last lemma ends in "end".
+func presenceRanges(witness string) []PresenceRange {
+	ranges := []PresenceRange{}
+	open := false
+	for i, passageURN := range passageURNs {
+		present := witnessRange[passageURN][witness]
+		switch {
+		case present && !open:
+			ranges = append(ranges, PresenceRange{From: passageURN})
+			open = true
+		case !present && open:
+			ranges[len(ranges)-1].To = passageURNs[i-1]
+			open = false
+		}
+	}
+	if open {
+		ranges[len(ranges)-1].To = "end"
+	}
+	return ranges
+}
+
+func presenceSummary() []PresenceSummary {
+	summary := []PresenceSummary{}
+	for _, witness := range presenceWitnesses() {
+		summary = append(summary, PresenceSummary{Witness: witness, Siglum: siglaMap[witness], Ranges: presenceRanges(witness)})
+	}
+	return summary
+}
+
+func formatPresence(summary PresenceSummary) string {
+	runs := []string{}
+	for _, r := range summary.Ranges {
+		runs = append(runs, r.From+"–"+r.To)
+	}
+	return summary.Witness + ": " + strings.Join(runs, ", ")
+}
+
+// writePresenceCSV writes the witness × lemma matrix, one row per witness
+// and one column per lemma, with 1 for present and 0 for absent.
+func writePresenceCSV(filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	w := csv.NewWriter(f)
+	w.Write(append([]string{"witness", "siglum"}, passageURNs...))
+	for _, witness := range presenceWitnesses() {
+		row := []string{witness, siglaMap[witness]}
+		for _, passageURN := range passageURNs {
+			if witnessRange[passageURN][witness] {
+				row = append(row, "1")
+			} else {
+				row = append(row, "0")
+			}
+		}
+		w.Write(row)
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		panic(err)
+	}
+}
+
+func writePresenceSummary(filename string) {
+	f, err := os.Create(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	for _, summary := range presenceSummary() {
+		f.WriteString(formatPresence(summary))
+		f.WriteString("\n")
+	}
+}
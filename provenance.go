@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// version is the version of nutcracker written to the provenance records;
+// set it with -ldflags "-X main.version=...".
+var version = "dev"
+
+// AlignmentSettings describes the alignment records: the collection they
+// belong to, their editor and description. Timestamp fixes their date for
+// reproducible builds (RFC 3339); when empty, SOURCE_DATE_EPOCH or else the
+// time of the run is used.
+type AlignmentSettings struct {
+	Collection  string `json:"collection"`
+	Editor      string `json:"editor"`
+	Description string `json:"description"`
+	Timestamp   string `json:"timestamp"`
+}
+
+const provenanceCollection = "urn:cite2:ducat:provenance.temp:"
+
+// Provenance records an input file a CEX was made from.
+type Provenance struct {
+	ID      string `json:"id"`
+	Tool    string `json:"tool"`
+	Version string `json:"version"`
+	Input   string `json:"input"`
+	SHA256  string `json:"sha256"`
+	Date    string `json:"date"`
+}
+
+var runTime time.Time
+
+// provenanceCache holds the records once the inputs were hashed.
+var provenanceCache []Provenance
+
+// cexDate is the date format of the alignment records.
+const cexDate = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// runTimestamp decides the date of this run once.
+func runTimestamp() time.Time {
+	if !runTime.IsZero() {
+		return runTime
+	}
+	switch {
+	case config.Alignments.Timestamp != "":
+		t, err := time.Parse(time.RFC3339, config.Alignments.Timestamp)
+		if err != nil {
+			log.Fatalln("alignments.timestamp:", err)
+		}
+		runTime = t
+	case os.Getenv("SOURCE_DATE_EPOCH") != "":
+		seconds, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64)
+		if err != nil {
+			log.Fatalln("SOURCE_DATE_EPOCH:", err)
+		}
+		runTime = time.Unix(seconds, 0)
+	default:
+		runTime = time.Now()
+	}
+	runTime = runTime.UTC()
+	return runTime
+}
+
+// alignmentLabel names an alignment by its passage and the beginning of the
+// base reading.
+func alignmentLabel(passage, base string) string {
+	words := strings.Fields(base)
+	if len(words) > 6 {
+		words = append(words[:6], "…")
+	}
+	if len(words) == 0 {
+		return passage
+	}
+	return passage + ": " + strings.Join(words, " ")
+}
+
+// provenanceRecords are the inputs of a model loaded with -model, which
+// provenance lists before the model itself.
+var provenanceRecords []Provenance
+
+// provenance hashes the input files, or the model read instead of them,
+// once per run.
+func provenance() []Provenance {
+	if provenanceCache != nil {
+		return provenanceCache
+	}
+	records := append([]Provenance{}, provenanceRecords...)
+	files := inputFiles()
+	if *modelFile != "" {
+		files = []string{*modelFile}
+	}
+	for _, filename := range files {
+		records = append(records, Provenance{
+			ID:      provenanceCollection + strconv.Itoa(len(records)+1),
+			Tool:    "nutcracker",
+			Version: version,
+			Input:   filepath.Base(filename),
+			SHA256:  hashFile(filename),
+			Date:    runTimestamp().Format(cexDate),
+		})
+	}
+	provenanceCache = records
+	return records
+}
+
+func hashFile(filename string) string {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func writeProvenanceCEX(f *bufio.Writer) {
+	f.WriteString("#!citedata\n")
+	f.WriteString("urn#tool#version#input#sha256#date\n")
+	for _, record := range provenance() {
+		f.WriteString(record.ID)
+		for _, v := range []string{record.Tool, record.Version, record.Input, record.SHA256, record.Date} {
+			f.WriteString("#")
+			f.WriteString(cexField(v))
+		}
+		f.WriteString("\n")
+	}
+	f.WriteString("\n")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// RangeOverride sets the presence of a witness from one passage up to and
+// including another; To may be "end".
+type RangeOverride struct {
+	Witness string `json:"witness"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Present bool   `json:"present"`
+	Line    int    `json:"-"`
+}
+
+var rangeOverrides = []RangeOverride{}
+
+// loadRangeOverrides reads a JSON list of overrides or, for any other
+// extension, a CSV file with the header witness,from,to,present.
+func loadRangeOverrides(filename string) {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(strings.ToLower(filename), ".json") {
+		if err := json.NewDecoder(f).Decode(&rangeOverrides); err != nil {
+			log.Fatalln(filename+":", err)
+		}
+		for i := range rangeOverrides {
+			rangeOverrides[i].Line = i + 1
+		}
+	} else {
+		reader := csv.NewReader(f)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		lineNo := 0
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Fatalln(filename+":", err)
+			}
+			lineNo++
+			if lineNo == 1 && record[0] == "witness" {
+				continue
+			}
+			if len(record) < 3 {
+				log.Fatalln(filename, "line", lineNo, "needs witness,from,to[,present]")
+			}
+			override := RangeOverride{Witness: record[0], From: record[1], To: record[2], Present: true, Line: lineNo}
+			if len(record) > 3 && record[3] != "" {
+				present, err := strconv.ParseBool(record[3])
+				if err != nil {
+					log.Fatalln(filename, "line", lineNo, err)
+				}
+				override.Present = present
+			}
+			rangeOverrides = append(rangeOverrides, override)
+		}
+	}
+	// the ends may be given as CTS URNs, like -passages
+	for i, override := range rangeOverrides {
+		rangeOverrides[i].From = passageKey(override.From)
+		if override.To != "end" {
+			rangeOverrides[i].To = passageKey(override.To)
+		}
+	}
+	log.Println("Loaded", len(rangeOverrides), "witness range overrides.")
+}
+
+func inOverride(passageURN string, override RangeOverride) bool {
+	if compareURN(passageURN, override.From) < 0 {
+		return false
+	}
+	return override.To == "end" || compareURN(passageURN, override.To) <= 0
+}
+
+// applyRangeOverrides writes the overrides into witnessRange, in file order.
+// Witnesses may be given by id or by resolved siglum.
+func applyRangeOverrides() {
+	for i, override := range rangeOverrides {
+		if _, ok := siglaMap[override.Witness]; !ok {
+			if witness, ok := inverseSiglaMap[override.Witness]; ok {
+				rangeOverrides[i].Witness = witness
+				override.Witness = witness
+			} else {
+				warn("range override", override.Line, "names unknown witness", override.Witness)
+			}
+		}
+		matched := 0
+		for _, passageURN := range passageURNs {
+			if !inOverride(passageURN, override) {
+				continue
+			}
+			if len(witnessRange[passageURN]) == 0 {
+				witnessRange[passageURN] = make(map[string]bool)
+			}
+			witnessRange[passageURN][override.Witness] = override.Present
+			matched++
+		}
+		if matched == 0 {
+			warn("range override", override.Line, "for", override.Witness, "matches no passage")
+		}
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Report is the machine-readable form of report.json; report.txt is
+// rendered from it. Passages stay empty: writeReports writes them chapter
+// by chapter as they are generated.
+type Report struct {
+	Sigla        []SiglumReport      `json:"sigla"`
+	Groups       []GroupReport       `json:"groups,omitempty"`
+	Passages     []PassageReport     `json:"passages"`
+	Presence     []PresenceReport    `json:"presence"`
+	PresenceRuns []PresenceSummary   `json:"presenceRuns"`
+	Conjectures  []PassageReport     `json:"conjectures"`
+	Equivalences []EquivalenceReport `json:"equivalences"`
+	Warnings     []string            `json:"warnings"`
+	Summary      SummaryReport       `json:"summary"`
+	Provenance   []Provenance        `json:"provenance"`
+}
+
+type SiglumReport struct {
+	Witness    string       `json:"witness"`
+	Siglum     string       `json:"siglum"`
+	Manuscript *Manuscript  `json:"manuscript,omitempty"`
+	Raw        string       `json:"raw"`
+	Steps      []SiglumStep `json:"steps,omitempty"`
+}
+
+type GroupReport struct {
+	Group   string   `json:"group"`
+	Label   string   `json:"label"`
+	Members []string `json:"members"`
+	Sigla   string   `json:"sigla"`
+}
+
+type PassageReport struct {
+	URN      string          `json:"urn"`
+	Base     string          `json:"base,omitempty"`
+	Readings []ReadingReport `json:"readings"`
+}
+
+// ReadingReport.Status is "reading" for an explicit reading, "inherited"
+// when the base text was taken over, "unknown" when the witness was not
+// collated and "absent" outside the witness range.
+type ReadingReport struct {
+	Witness     string `json:"witness"`
+	Reading     string `json:"reading"`
+	Status      string `json:"status,omitempty"`
+	Equivalence string `json:"equivalence,omitempty"`
+	Source      string `json:"source,omitempty"`
+	Group       string `json:"group,omitempty"`
+	TEI         string `json:"tei,omitempty"`
+}
+
+type PresenceReport struct {
+	Passage   string          `json:"passage"`
+	Witnesses []PresenceEntry `json:"witnesses"`
+}
+
+type PresenceEntry struct {
+	Witness string `json:"witness"`
+	Siglum  string `json:"siglum"`
+	Present bool   `json:"present"`
+}
+
+type EquivalenceReport struct {
+	Rule       string `json:"rule"`
+	Suppressed int    `json:"suppressed"`
+}
+
+type SummaryReport struct {
+	Lemmata            int `json:"lemmata"`
+	Witnesses          int `json:"witnesses"`
+	SecondaryWitnesses int `json:"secondaryWitnesses"`
+	Readings           int `json:"readings"`
+	Inherited          int `json:"inherited"`
+	Unknown            int `json:"unknown"`
+	Absent             int `json:"absent"`
+	Conjectures        int `json:"conjectures"`
+	Suppressed         int `json:"suppressed"`
+	Warnings           int `json:"warnings"`
+}
+
+func passageKeys(m map[string]map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return compareURN(keys[i], keys[j]) < 0 })
+	return keys
+}
+
+// completeReport fills in every section but the passages and what is
+// counted over them.
+func completeReport(report Report) Report {
+	for _, k := range sortedStringKeys(siglaMap) {
+		siglum := SiglumReport{Witness: k, Siglum: siglaMap[k]}
+		if ms, ok := manuscripts[k]; ok {
+			siglum.Manuscript = &ms
+		}
+		if transform, ok := siglumTransforms[k]; ok {
+			siglum.Raw = transform.Raw
+			siglum.Steps = transform.Steps
+		}
+		report.Sigla = append(report.Sigla, siglum)
+	}
+	report.Groups = groupReport()
+	report.Provenance = provenance()
+
+	presenceKeys := []string{}
+	for k := range witnessRange {
+		presenceKeys = append(presenceKeys, k)
+	}
+	sort.Slice(presenceKeys, func(i, j int) bool { return compareURN(presenceKeys[i], presenceKeys[j]) < 0 })
+	for _, k := range presenceKeys {
+		presence := PresenceReport{Passage: k}
+		for _, k2 := range sortedKeys(witnessRange[k]) {
+			presence.Witnesses = append(presence.Witnesses, PresenceEntry{Witness: k2, Siglum: siglaMap[k2], Present: witnessRange[k][k2]})
+		}
+		report.Presence = append(report.Presence, presence)
+	}
+	report.PresenceRuns = presenceSummary()
+
+	for _, k := range passageKeys(secPositionMap) {
+		conjecture := PassageReport{URN: k}
+		for _, k2 := range sortedStringKeys(secPositionMap[k]) {
+			conjecture.Readings = append(conjecture.Readings, ReadingReport{Witness: k2, Reading: secPositionMap[k][k2], TEI: readingTEI(k, k2)})
+		}
+		report.Conjectures = append(report.Conjectures, conjecture)
+		report.Summary.Conjectures += len(conjecture.Readings)
+	}
+
+	report.Warnings = warnings
+	report.Summary.Witnesses = len(witnessMap)
+	report.Summary.SecondaryWitnesses = len(secWitnessMap)
+	report.Summary.Warnings = len(warnings)
+	return report
+}
+
+// countReadings adds a passage to the summary.
+func countReadings(summary *SummaryReport, passage PassageReport) {
+	summary.Lemmata++
+	for _, reading := range passage.Readings {
+		switch reading.Status {
+		case "reading":
+			summary.Readings++
+		case "inherited":
+			summary.Inherited++
+		case "unknown":
+			summary.Unknown++
+		case "absent":
+			summary.Absent++
+		}
+	}
+}
+
+// countEquivalences reports how many readings each equivalence rule
+// suppressed, once every passage has been generated.
+func countEquivalences(report *Report) {
+	for _, label := range equivalenceLabels {
+		report.Equivalences = append(report.Equivalences, EquivalenceReport{Rule: label, Suppressed: suppressedCount[label]})
+		report.Summary.Suppressed += suppressedCount[label]
+	}
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// writeReports writes report.json, report.txt and, when asked for, the
+// HTML viewer and the LaTeX edition. The readings of every witness are
+// generated one chapter at a time and written to each output before the
+// next chapter, so no more than a chapter of them is held at once; the
+// counts over all of them close the outputs.
+func writeReports() {
+	report := completeReport(Report{})
+	conjectures := make(map[string][]ReadingReport)
+	for _, conjecture := range report.Conjectures {
+		conjectures[conjecture.URN] = conjecture.Readings
+	}
+
+	jsonFile, err := os.Create("report.json")
+	if err != nil {
+		panic(err)
+	}
+	defer jsonFile.Close()
+	txtFile, err := os.Create("report.txt")
+	if err != nil {
+		panic(err)
+	}
+	defer txtFile.Close()
+	j := bufio.NewWriterSize(jsonFile, 1<<16)
+	txt := bufio.NewWriterSize(txtFile, 1<<16)
+	writeJSONHead(j, report)
+	writeTextHead(txt, report)
+	var site *htmlSite
+	if *htmlDir != "" {
+		site = startHTML(*htmlDir)
+	}
+	var edition *latexEdition
+	if *latexFile != "" {
+		edition = startLaTeX(*latexFile)
+	}
+
+	first := PassageReport{}
+	passages := []PassageReport{}
+	for i := range basetext {
+		passage := passageReport(i)
+		if i == 0 {
+			first = PassageReport{URN: passage.URN, Base: passage.Base}
+		}
+		writeJSONPassage(j, passage, i)
+		writeTextPassage(txt, passage)
+		countReadings(&report.Summary, passage)
+		passages = append(passages, passage)
+		if i+1 < len(basetext) && chapterOf(passageURNs[i+1]) == chapterOf(passage.URN) {
+			continue
+		}
+		if site != nil {
+			writeHTMLPassages(site, passages, conjectures)
+		}
+		if edition != nil {
+			writeLaTeXPassages(edition, passages, conjectures)
+		}
+		passages = passages[:0]
+	}
+
+	countEquivalences(&report)
+	writeJSONTail(j, report)
+	writeTextTail(txt, report, first)
+	if err := j.Flush(); err != nil {
+		panic(err)
+	}
+	if err := txt.Flush(); err != nil {
+		panic(err)
+	}
+	if site != nil {
+		finishHTML(site, report)
+	}
+	if edition != nil {
+		finishLaTeX(edition)
+	}
+}
+
+// writeJSONValue writes v as the report encoder would at the given
+// indentation, without the trailing newline, so that report.json can be
+// written one section and one passage at a time.
+func writeJSONValue(w *bufio.Writer, prefix string, v interface{}) {
+	var b bytes.Buffer
+	encoder := json.NewEncoder(&b)
+	encoder.SetIndent(prefix, "  ")
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(v); err != nil {
+		panic(err)
+	}
+	w.Write(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+func writeJSONField(w *bufio.Writer, name string, v interface{}) {
+	w.WriteString(",\n  \"" + name + "\": ")
+	writeJSONValue(w, "  ", v)
+}
+
+func writeJSONHead(w *bufio.Writer, report Report) {
+	w.WriteString("{\n  \"sigla\": ")
+	writeJSONValue(w, "  ", report.Sigla)
+	if len(report.Groups) > 0 {
+		writeJSONField(w, "groups", report.Groups)
+	}
+	w.WriteString(",\n  \"passages\": ")
+}
+
+func writeJSONPassage(w *bufio.Writer, passage PassageReport, i int) {
+	if i == 0 {
+		w.WriteString("[\n    ")
+	} else {
+		w.WriteString(",\n    ")
+	}
+	writeJSONValue(w, "    ", passage)
+}
+
+func writeJSONTail(w *bufio.Writer, report Report) {
+	if report.Summary.Lemmata == 0 {
+		w.WriteString("null")
+	} else {
+		w.WriteString("\n  ]")
+	}
+	writeJSONField(w, "presence", report.Presence)
+	writeJSONField(w, "presenceRuns", report.PresenceRuns)
+	writeJSONField(w, "conjectures", report.Conjectures)
+	writeJSONField(w, "equivalences", report.Equivalences)
+	writeJSONField(w, "warnings", report.Warnings)
+	writeJSONField(w, "summary", report.Summary)
+	writeJSONField(w, "provenance", report.Provenance)
+	w.WriteString("\n}\n")
+}
+
+func writeTextHead(b *bufio.Writer, report Report) {
+	b.WriteString("### Sigla Abbreviations ###\n\n")
+	for _, siglum := range report.Sigla {
+		b.WriteString(fmt.Sprintln("key:", siglum.Witness, "value:", siglum.Siglum))
+		if len(siglum.Steps) > 0 {
+			b.WriteString(fmt.Sprintf("    raw: %q\n", siglum.Raw))
+			for _, step := range siglum.Steps {
+				b.WriteString(fmt.Sprintf("    %s (%s): %q -> %q\n", step.Rule, step.Scope, step.Before, step.After))
+			}
+		}
+	}
+
+	for _, group := range report.Groups {
+		b.WriteString(fmt.Sprintln("group:", group.Label, "members:", group.Sigla))
+	}
+
+	b.WriteString("\n\n")
+	b.WriteString("### Readings & Variants ###\n\n")
+}
+
+func writeTextPassage(b *bufio.Writer, passage PassageReport) {
+	b.WriteString("---------------------------------------------\n")
+	b.WriteString(fmt.Sprintln("Position: ", passage.URN, "Reading:", passage.Base))
+	b.WriteString("\n")
+	b.WriteString("Variants:\n")
+	for _, reading := range passage.Readings {
+		switch {
+		case reading.Equivalence != "":
+			b.WriteString(fmt.Sprintln(reading.Witness, "Reading:", reading.Reading, "(agrees by", reading.Equivalence+")"))
+		case reading.Group != "":
+			b.WriteString(fmt.Sprintln(reading.Witness, "Reading:", reading.Reading, "(cited as", reading.Group+")"))
+		default:
+			b.WriteString(fmt.Sprintln(reading.Witness, "Reading:", reading.Reading))
+		}
+	}
+}
+
+func writeTextTail(b *bufio.Writer, report Report, first PassageReport) {
+	b.WriteString("\n\n")
+	b.WriteString("$$$ First Passage $$$\n")
+	if report.Summary.Lemmata > 0 {
+		b.WriteString(fmt.Sprintln("Position:", first.URN, "Reading:", first.Base))
+	}
+	b.WriteString(fmt.Sprintln("Parsed", report.Summary.Lemmata, "lemmata..."))
+
+	b.WriteString("\n\n")
+	b.WriteString("_Witness Present?__\n")
+	for _, summary := range report.PresenceRuns {
+		b.WriteString(fmt.Sprintln(formatPresence(summary), "("+summary.Siglum+")"))
+	}
+
+	b.WriteString("\n\n")
+	b.WriteString("+++Conjectures+++\n")
+	for _, conjecture := range report.Conjectures {
+		b.WriteString(fmt.Sprintln("Passage:", conjecture.URN))
+		for _, reading := range conjecture.Readings {
+			b.WriteString(fmt.Sprintln("key:", reading.Witness, "value:", reading.Reading))
+		}
+	}
+
+	b.WriteString("\n\n")
+	b.WriteString("### Equivalence Rules ###\n")
+	for _, equivalence := range report.Equivalences {
+		b.WriteString(fmt.Sprintln("Rule:", equivalence.Rule, "suppressed:", equivalence.Suppressed))
+	}
+
+	b.WriteString("\n\n")
+	b.WriteString("!!! Warnings !!!\n")
+	for _, warning := range report.Warnings {
+		b.WriteString(fmt.Sprintln(warning))
+	}
+
+	b.WriteString("\n\n")
+	b.WriteString("### Summary ###\n")
+	b.WriteString(fmt.Sprintln("Lemmata:", report.Summary.Lemmata))
+	b.WriteString(fmt.Sprintln("Witnesses:", report.Summary.Witnesses, "secondary:", report.Summary.SecondaryWitnesses))
+	b.WriteString(fmt.Sprintln("Readings:", report.Summary.Readings, "inherited:", report.Summary.Inherited, "unknown:", report.Summary.Unknown, "absent:", report.Summary.Absent))
+	b.WriteString(fmt.Sprintln("Conjectures:", report.Summary.Conjectures))
+	b.WriteString(fmt.Sprintln("Suppressed by equivalence:", report.Summary.Suppressed))
+	b.WriteString(fmt.Sprintln("Warnings:", report.Summary.Warnings))
+}
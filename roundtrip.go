@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"strings"
+)
+
+var checkOutput = flag.Bool("check", false, "read output.cex back and check it; this holds the whole CEX in memory")
+
+// workOf returns the URN up to and including the last colon, the work or
+// collection a passage or object belongs to.
+func workOf(urn string) string {
+	return urn[:strings.LastIndex(urn, ":")+1]
+}
+
+// checkCEX checks that every catalogued text has data and every passage a
+// catalogue entry, that citedata objects belong to declared collections,
+// and that every relation points to passages and objects that exist. URNs
+// must be unique and alignments follow their datamodel.
+func checkCEX(cex CEX) []CEXError {
+	errors := checkDuplicates(cex)
+	errors = append(errors, checkProperties(cex)...)
+	errors = append(errors, checkAlignmentModel(cex)...)
+	nodes := make(map[string]bool)
+	works := make(map[string]bool)
+	for _, node := range cex.Nodes {
+		nodes[node.URN] = true
+		works[workOf(node.URN)] = true
+	}
+	catalogued := make(map[string]bool)
+	for _, entry := range cex.Catalog {
+		catalogued[entry.URN] = true
+		if !works[entry.URN] {
+			errors = append(errors, CEXError{Block: "#!ctscatalog", Line: entry.Line, Msg: "no ctsdata for " + entry.URN})
+		}
+	}
+	for _, node := range cex.Nodes {
+		if !catalogued[workOf(node.URN)] {
+			errors = append(errors, CEXError{Block: "#!ctsdata", Line: node.Line, Msg: "passage " + node.URN + " of an uncatalogued text"})
+		}
+	}
+
+	collections := make(map[string]bool)
+	for _, collection := range cex.Collections {
+		collections[collection.URN] = true
+	}
+	objects := make(map[string]bool)
+	for _, data := range cex.Data {
+		for _, record := range data.Records {
+			objects[record.Fields[0]] = true
+			if !collections[workOf(record.Fields[0])] {
+				errors = append(errors, CEXError{Block: "#!citedata", Line: record.Line, Msg: record.Fields[0] + " belongs to no declared collection"})
+			}
+		}
+	}
+
+	exists := func(urn string) string {
+		switch {
+		case strings.HasPrefix(urn, "urn:cts:"):
+			work := workOf(urn)
+			passage := strings.TrimPrefix(urn, work)
+			if passage == "" {
+				if !catalogued[work] {
+					return "uncatalogued text " + urn
+				}
+				return ""
+			}
+			for _, part := range strings.Split(passage, "-") {
+				if i := strings.Index(part, "@"); i >= 0 {
+					part = part[:i]
+				}
+				if !nodes[work+part] {
+					return "no passage " + work + part
+				}
+			}
+		case strings.HasPrefix(urn, "urn:cite2:"):
+			if strings.HasSuffix(urn, ":") {
+				if !collections[urn] {
+					return "no collection " + urn
+				}
+			} else if !objects[urn] {
+				return "no citedata row for " + urn
+			}
+		default:
+			return "not a CTS or CITE2 URN: " + urn
+		}
+		return ""
+	}
+	for _, relation := range cex.Relations {
+		if msg := exists(relation.Subject); msg != "" {
+			if relation.Verb == "urn:cite2:cite:verbs.v1:aligns" {
+				msg = "alignment without a citedata row: " + relation.Subject
+			}
+			errors = append(errors, CEXError{Block: "#!relations", Line: relation.Line, Msg: msg})
+		}
+		if msg := exists(relation.Object); msg != "" {
+			errors = append(errors, CEXError{Block: "#!relations", Line: relation.Line, Msg: msg})
+		}
+	}
+	return errors
+}
+
+// checkRoundTrip reads back the CEX written by writeCEX and logs what is
+// wrong with it.
+func checkRoundTrip(filename string) {
+	cex, errors := readBack(filename)
+	for _, e := range errors {
+		log.Println(filename+":", e)
+	}
+	log.Println(filename+": read back", len(cex.Catalog), "texts,", len(cex.Nodes), "passages,", len(cex.Relations), "relations,", len(errors), "problems")
+}
+
+// readBack parses a written CEX, checks it and compares its passages with
+// the editions, generated again lemma by lemma.
+func readBack(filename string) (CEX, []CEXError) {
+	f, err := os.Open(filename)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	cex, errors := parseCEX(f)
+	errors = append(errors, checkCEX(cex)...)
+	written := make(map[string]string)
+	for _, node := range cex.Nodes {
+		written[node.URN] = node.Text
+	}
+	for _, witkey := range editionWitnesses() {
+		for _, editionURN := range editionURNs(witkey) {
+			for i, keyStr := range passageURNs {
+				for _, passage := range editionPassages(editionURN, witkey, keyStr, editionText(witkey, i)) {
+					text, ok := written[passage.ID]
+					switch {
+					case !ok:
+						errors = append(errors, CEXError{Block: "#!ctsdata", Msg: "passage " + passage.ID + " was not written"})
+					case text != passage.Passage:
+						errors = append(errors, CEXError{Block: "#!ctsdata", Msg: "passage " + passage.ID + " reads back as " + text})
+					}
+				}
+			}
+		}
+	}
+	return cex, errors
+}
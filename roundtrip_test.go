@@ -0,0 +1,69 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// resetCollation empties the tables a run fills, so that every test and
+// benchmark converts its own collation from the given files.
+func resetCollation(files ...string) {
+	inputs = files
+	provenanceRecords = nil
+	provenanceCache = nil
+	siglaMap = make(map[string]string)
+	inverseSiglaMap = make(map[string]string)
+	siglumTransforms = make(map[string]*SiglumTransform)
+	manuscripts = make(map[string]Manuscript)
+	witnessFiles = make(map[string]string)
+	witnessGroups = make(map[string]WitnessGroup)
+	witnessMap = make(map[string]bool)
+	secWitnessMap = make(map[string]bool)
+	witnessRange = make(map[string]map[string]bool)
+	witBool = make(map[string]bool)
+	positionMap = map[string]map[string]string{}
+	secPositionMap = map[string]map[string]string{}
+	layerMap = map[string]map[string]string{}
+	readingMarkup = map[string]map[string][]Inline{}
+	readingSources = map[string]map[string]ReadingSource{}
+	anchorLocations = map[string]ReadingSource{}
+	candidateCache = make(map[string][][2]string)
+	equivalenceMap = make(map[string]map[string]string)
+	suppressedCount = make(map[string]int)
+	basetext = []string{}
+	passageURNs = []string{}
+	passageIndex = nil
+	readingStatuses = []ReadingStatus{}
+	notes = []Note{}
+	notesPerPassage = make(map[string]int)
+	warnings = []string{}
+	appRecords = []AppRecord{}
+	witnessEvents = []WitnessEvent{}
+	duplicateReadings = []DuplicateReading{}
+}
+
+// TestRoundTrip converts a small collation with hands, variae lectiones,
+// notes and two chapters and reads the CEX back.
+func TestRoundTrip(t *testing.T) {
+	fixture, err := filepath.Abs(filepath.Join("testdata", "collation.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Chdir(t.TempDir())
+	resetCollation(fixture)
+	loadConfig("nutcracker.json")
+	loadCollation()
+	for _, warning := range warnings {
+		t.Error("warning:", warning)
+	}
+	writeReports()
+	writeCEX()
+
+	cex, errors := readBack("output.cex")
+	if len(cex.Nodes) == 0 {
+		t.Fatal("no passages read back")
+	}
+	for _, e := range errors {
+		t.Error(e)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+)
+
+var passageRange = flag.String("passages", "", "only output the passages in this range, e.g. 3.1.1.5-3.1.2 or 3.1.2")
+var includeWitnesses = flag.String("witnesses", "", "only output these witnesses (ids, sigla or groups, comma separated)")
+var excludeWitnesses = flag.String("exclude", "", "leave out these witnesses (ids, sigla or groups, comma separated)")
+var includeLayers = flag.String("layers", "", "only output readings of these app types, e.g. a1,a2")
+
+func splitList(s string) []string {
+	list := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// parsePassageRange returns the first and last passage of a range such as
+// 3.1.1.5-3.1.2, given bare or as a CTS URN. Either end may be left open.
+func parsePassageRange(s string) (string, string) {
+	if i := strings.LastIndex(s, ":"); i >= 0 {
+		s = s[i+1:]
+	}
+	parts := strings.SplitN(s, "-", 2)
+	from := passageKey(parts[0])
+	if len(parts) == 1 {
+		return from, from
+	}
+	return from, passageKey(parts[1])
+}
+
+// inPassageRange tells whether a passage lies in the range; a reference
+// with fewer levels, such as a chapter, covers all passages below it.
+func inPassageRange(passageURN, from, to string) bool {
+	if from != "" && compareURN(passageURN, from) < 0 {
+		return false
+	}
+	if to != "" && compareURN(passageURN, to) > 0 && !strings.HasPrefix(passageURN, to+".") {
+		return false
+	}
+	return true
+}
+
+// selectedWitnesses resolves a list of witness ids, sigla or groups to the
+// witness ids.
+func selectedWitnesses(list string) map[string]bool {
+	selected := make(map[string]bool)
+	for _, name := range splitList(list) {
+		switch {
+		case witnessGroups[name].Members != nil:
+			members, _ := expandWitness(name)
+			for _, member := range members {
+				selected[member] = true
+			}
+		case siglumTransforms[name] != nil:
+			selected[name] = true
+		case inverseSiglaMap[name] != "":
+			selected[inverseSiglaMap[name]] = true
+		default:
+			log.Fatalln("no witness, siglum or group", name)
+		}
+	}
+	return selected
+}
+
+// selectCollation drops everything outside the passage range, the witness
+// selection and the layers from the parsed collation, so that every writer
+// only sees what was selected. The tables keyed by passage and witness
+// besides positionMap and secPositionMap are only looked up from these and
+// are left as they are.
+func selectCollation() {
+	if *passageRange == "" && *includeWitnesses == "" && *excludeWitnesses == "" && *includeLayers == "" {
+		return
+	}
+	from, to := "", ""
+	if *passageRange != "" {
+		from, to = parsePassageRange(*passageRange)
+	}
+
+	included := selectedWitnesses(*includeWitnesses)
+	excluded := selectedWitnesses(*excludeWitnesses)
+	keepWitness := func(witness string) bool {
+		return (*includeWitnesses == "" || included[witness]) && !excluded[witness]
+	}
+	// hands such as M10_2pc go with their witness
+	witnessOfKey := make(map[string]string)
+	for k := range siglaMap {
+		witnessOfKey[k] = baseWitnessOf(siglaMap[k])
+	}
+	keepKey := func(witkey string) bool {
+		return keepWitness(baseWitnessOf(witkey))
+	}
+
+	layers := make(map[string]bool)
+	for _, layer := range splitList(*includeLayers) {
+		layers[layer] = true
+	}
+	known := make(map[string]bool)
+	layered := make(map[string]bool)
+	for passageURN, m := range layerMap {
+		for witkey, layer := range m {
+			known[layer] = true
+			_, primary := positionMap[passageURN][witkey]
+			if layers[layer] && primary {
+				layered[witkey] = true
+			}
+		}
+	}
+	for layer := range layers {
+		if !known[layer] {
+			log.Fatalln("no readings of layer", layer)
+		}
+	}
+	keepReading := func(passageURN, witkey string) bool {
+		if !inPassageRange(passageURN, from, to) || !keepKey(witkey) {
+			return false
+		}
+		return len(layers) == 0 || layers[layerMap[passageURN][witkey]]
+	}
+
+	for _, positions := range []map[string]map[string]string{positionMap, secPositionMap} {
+		for passageURN, readings := range positions {
+			for witkey := range readings {
+				if !keepReading(passageURN, witkey) {
+					delete(readings, witkey)
+				}
+			}
+			if len(readings) == 0 {
+				delete(positions, passageURN)
+			}
+		}
+	}
+	// a hand such as M10_2pc that only reads in left out layers goes too,
+	// the witness itself stays with the base text
+	for witkey := range witnessMap {
+		hand := siglaMap[baseWitnessOf(witkey)] != witkey
+		if !keepKey(witkey) || (len(layers) > 0 && hand && !layered[witkey]) {
+			delete(witnessMap, witkey)
+		}
+	}
+	for witkey := range secWitnessMap {
+		if !keepKey(witkey) {
+			delete(secWitnessMap, witkey)
+		}
+	}
+
+	passages, texts := []string{}, []string{}
+	for i, passageURN := range passageURNs {
+		if inPassageRange(passageURN, from, to) {
+			passages = append(passages, passageURN)
+			texts = append(texts, basetext[i])
+			continue
+		}
+		delete(witnessRange, passageURN)
+		delete(anchorLocations, passageURN)
+	}
+	if len(passages) == 0 {
+		log.Fatalln("no passage in", *passageRange)
+	}
+	passageURNs, basetext = passages, texts
+	for passageURN, presence := range witnessRange {
+		if !inPassageRange(passageURN, from, to) {
+			delete(witnessRange, passageURN)
+			continue
+		}
+		for witness := range presence {
+			if !keepWitness(witness) {
+				delete(presence, witness)
+			}
+		}
+	}
+	selectedNotes := []Note{}
+	for _, note := range notes {
+		if inPassageRange(note.Passage, from, to) {
+			selectedNotes = append(selectedNotes, note)
+		}
+	}
+	notes = selectedNotes
+
+	for k, witness := range witnessOfKey {
+		if keepWitness(witness) {
+			continue
+		}
+		delete(inverseSiglaMap, siglaMap[k])
+		delete(siglaMap, k)
+		delete(siglumTransforms, k)
+		delete(manuscripts, k)
+	}
+	for id, group := range witnessGroups {
+		members := []string{}
+		for _, member := range group.Members {
+			if _, ok := witnessGroups[member]; ok || keepWitness(member) {
+				members = append(members, member)
+			}
+		}
+		group.Members = members
+		witnessGroups[id] = group
+		if len(members) == 0 {
+			delete(witnessGroups, id)
+		}
+	}
+	log.Println("Selected", len(passageURNs), "passages and", len(witnessMap), "witnesses.")
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// SiglumRule rewrites part of a siglum from <listWit>. Scope is "abbr" for
+// the siglum itself, "hi" for its extensions such as (^!), or empty for both.
+type SiglumRule struct {
+	Label       string `json:"label"`
+	Scope       string `json:"scope,omitempty"`
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+	re          *regexp.Regexp
+}
+
+// defaultSiglumRules reproduce the normalisation the collation was made for.
+var defaultSiglumRules = []SiglumRule{
+	{Label: "note", Scope: "abbr", Pattern: `\^!`, Replacement: "_Note"},
+	{Label: "note", Scope: "hi", Pattern: `\^!`, Replacement: "Note"},
+	{Label: "parentheses", Pattern: `[()]`, Replacement: ""},
+	{Label: "trim", Pattern: `^[\s\p{Zs}]+|[\s\p{Zs}]+$`, Replacement: ""},
+	{Label: "spaces", Pattern: `\s+`, Replacement: "_"},
+	// U+2006 SIX-PER-EM SPACE, you almost cannot see the difference
+	{Label: "six-per-em space", Scope: "abbr", Pattern: `\x{2006}`, Replacement: ""},
+	{Label: "six-per-em space", Scope: "hi", Pattern: `\x{2006}_`, Replacement: "_"},
+}
+
+// SiglumStep is one rule that changed a part of a siglum.
+type SiglumStep struct {
+	Rule   string `json:"rule"`
+	Scope  string `json:"scope"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// SiglumTransform records how the raw siglum of a witness was resolved.
+type SiglumTransform struct {
+	Witness string       `json:"witness"`
+	Raw     string       `json:"raw"`
+	Steps   []SiglumStep `json:"steps,omitempty"`
+	Final   string       `json:"final"`
+}
+
+var siglumTransforms = make(map[string]*SiglumTransform)
+
+func compileSiglumRules() {
+	for i := range config.Sigla {
+		rule := &config.Sigla[i]
+		switch rule.Scope {
+		case "", "abbr", "hi":
+		default:
+			log.Fatalln("siglum rule", rule.Label+": scope must be abbr, hi or empty, not", rule.Scope)
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			log.Fatalln("siglum rule", rule.Label+":", err)
+		}
+		rule.re = re
+	}
+}
+
+// resolveSiglumPart applies the rules of scope to one part of a siglum and
+// records every rule that changed it.
+func resolveSiglumPart(transform *SiglumTransform, part, scope string) string {
+	for _, rule := range config.Sigla {
+		if rule.Scope != "" && rule.Scope != scope {
+			continue
+		}
+		after := rule.re.ReplaceAllString(part, rule.Replacement)
+		if after != part {
+			transform.Steps = append(transform.Steps, SiglumStep{Rule: rule.Label, Scope: scope, Before: part, After: after})
+		}
+		part = after
+	}
+	return part
+}
+
+// resolveWitnessSiglum builds the siglum of a witness from its abbreviations
+// and their extensions, joined by "_".
+func resolveWitnessSiglum(witness WitnessMeta) *SiglumTransform {
+	transform := &SiglumTransform{Witness: strings.TrimSpace(witness.ID)}
+	value := []string{}
+	for _, abbrev := range witness.Abbrevs {
+		transform.Raw = transform.Raw + abbrev.Name
+		if id := resolveSiglumPart(transform, abbrev.Name, "abbr"); id != "" {
+			value = append(value, id)
+		}
+		for _, extension := range abbrev.Extensions {
+			transform.Raw = transform.Raw + extension.Name
+			if id := resolveSiglumPart(transform, extension.Name, "hi"); id != "" {
+				value = append(value, id)
+			}
+		}
+	}
+	transform.Final = strings.Join(value, "_")
+	return transform
+}
+
+// validateSigla warns about witnesses whose sigla resolve to the same
+// URN component.
+func validateSigla() {
+	byFinal := make(map[string][]string)
+	for _, witness := range sortedStringKeys(siglaMap) {
+		byFinal[siglaMap[witness]] = append(byFinal[siglaMap[witness]], witness)
+	}
+	finals := make(map[string]bool)
+	for final := range byFinal {
+		finals[final] = true
+	}
+	for _, final := range sortedKeys(finals) {
+		witnesses := byFinal[final]
+		if len(witnesses) < 2 {
+			continue
+		}
+		raws := []string{}
+		for _, witness := range witnesses {
+			// derived keys such as M10_pc have no transform of their own
+			raw := witness
+			if transform, ok := siglumTransforms[witness]; ok {
+				raw = normaliseSpace(transform.Raw)
+			}
+			raws = append(raws, witness+" ("+raw+")")
+		}
+		warn("sigla collide as", final+":", strings.Join(raws, ", "))
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+)
+
+type AppRecord struct {
+	URN        string
+	To         string
+	BeforeText bool
+	Source     ReadingSource
+}
+
+type WitnessEvent struct {
+	Witness string
+	Kind    string
+	Passage string
+	Source  ReadingSource
+}
+
+type DuplicateReading struct {
+	Passage  string
+	Witness  string
+	Readings [2]string
+	Sources  [2]ReadingSource
+}
+
+var appRecords = []AppRecord{}
+var witnessEvents = []WitnessEvent{}
+var duplicateReadings = []DuplicateReading{}
+
+// validate checks the parsed collation for inconsistencies and reports them
+// as warnings.
+func validate() {
+	log.Println("Validating...")
+	validateSigla()
+	validateOverrides()
+	validateApps()
+	validateWitnessEvents()
+	validateDuplicates()
+	validateReadingRanges()
+}
+
+// validateOverrides warns where an override marks a witness absent although
+// it has an explicit reading there.
+func validateOverrides() {
+	for _, override := range rangeOverrides {
+		if override.Present {
+			continue
+		}
+		for _, passageURN := range passageURNs {
+			if !inOverride(passageURN, override) {
+				continue
+			}
+			for _, witkey := range sortedStringKeys(positionMap[passageURN]) {
+				if witnessOf(witkey) == override.Witness {
+					warn("range override", override.Line, "marks", override.Witness, "absent at", passageURN, "but", witkey, "has the reading", positionMap[passageURN][witkey])
+				}
+			}
+		}
+	}
+}
+
+// validateApps flags apps met before the text starts, whose readings end up
+// under a made-up passage, and apps filed under another passage than the
+// anchor they point to.
+func validateApps() {
+	anchorPassages := make(map[string]string)
+	for passageURN, anchor := range anchorLocations {
+		anchorPassages[anchor.Rule] = passageURN
+	}
+	for _, app := range appRecords {
+		if app.BeforeText {
+			warn("app at line", strconv.Itoa(app.Source.Line)+inFile(app.Source.File), "appears before any anchor of the text and is filed under", app.URN)
+			continue
+		}
+		if app.To == "" {
+			continue
+		}
+		target := strings.TrimPrefix(app.To, "#")
+		passageURN, ok := anchorPassages[target]
+		switch {
+		case !ok:
+			warn("app at line", strconv.Itoa(app.Source.Line)+inFile(app.Source.File), "points to anchor", target, "which is not part of the text")
+		case passageURN != app.URN:
+			warn("app at line", strconv.Itoa(app.Source.Line)+inFile(app.Source.File), "points to anchor", target, "at", passageURN, "but is filed under", app.URN)
+		}
+	}
+}
+
+// validateWitnessEvents pairs every witStart with the following witEnd of
+// the same witness.
+func validateWitnessEvents() {
+	started := make(map[string]WitnessEvent)
+	for _, event := range witnessEvents {
+		previous, open := started[event.Witness]
+		switch event.Kind {
+		case "witStart":
+			if open {
+				warn("witStart of", event.Witness, "at", event.Passage, "while it is already started at", previous.Passage)
+			}
+			started[event.Witness] = event
+		case "witEnd":
+			if !open {
+				warn("witEnd of", event.Witness, "at", event.Passage, "without a matching witStart")
+			}
+			delete(started, event.Witness)
+		}
+	}
+	for _, witness := range sortedEventKeys(started) {
+		warn("witStart of", witness, "at", started[witness].Passage, "without a matching witEnd")
+	}
+}
+
+func sortedEventKeys(m map[string]WitnessEvent) []string {
+	keys := make(map[string]bool)
+	for k := range m {
+		keys[k] = true
+	}
+	return sortedKeys(keys)
+}
+
+func validateDuplicates() {
+	for _, duplicate := range duplicateReadings {
+		warn("duplicate reading for", duplicate.Witness, "at", duplicate.Passage+":", duplicate.Readings[0], "("+duplicate.Sources[0].String()+")", "is overwritten by", duplicate.Readings[1], "("+duplicate.Sources[1].String()+")")
+	}
+}
+
+// validateReadingRanges flags explicit readings of witnesses that are not
+// present at the passage according to witnessRange. As in inheritReading, a
+// hand such as M11_2pc is present wherever its witness is.
+func validateReadingRanges() {
+	for _, passageURN := range passageURNs {
+		for _, witkey := range sortedStringKeys(positionMap[passageURN]) {
+			present := false
+			for _, candidate := range witnessCandidates(witkey) {
+				if witnessRange[passageURN][inverseSiglaMap[candidate[1]]] {
+					present = true
+					break
+				}
+			}
+			if !present {
+				warn("explicit reading of", witkey, "at", passageURN, "outside the range of witness", baseWitnessOf(witkey), "("+readingSources[passageURN][witkey].String()+")")
+			}
+		}
+	}
+}